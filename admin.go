@@ -0,0 +1,88 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "net/http"
+  "encoding/json"
+  "github.com/kekekekyle/database"
+)
+
+// parseAdminEmails reads the comma separated ADMIN_EMAILS setting
+func parseAdminEmails(value string) map[string]bool {
+  adminEmails := map[string]bool{}
+  for _, email := range strings.Split(value, ",") {
+    email = strings.TrimSpace(email)
+    if email != "" {
+      adminEmails[email] = true
+    }
+  }
+  return adminEmails
+}
+
+// isAdmin reports whether user is listed in ADMIN_EMAILS
+func (cfg *apiConfig) isAdmin (user database.User) bool {
+  return cfg.adminEmails[user.Email]
+}
+
+// authenticateAdmin only lets through authenticated users
+// whose email is listed in ADMIN_EMAILS
+func (cfg *apiConfig) authenticateAdmin (next http.Handler) http.Handler {
+  nextHandler := func (w http.ResponseWriter, r *http.Request) {
+    userHeader := r.Header.Get("User")
+    user := database.User{}
+    if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+      w.WriteHeader(500)
+      w.Write([]byte(fmt.Sprintf("%v", err)))
+      return
+    }
+
+    if !cfg.isAdmin(user) {
+      w.WriteHeader(403)
+      return
+    }
+    next.ServeHTTP(w, r)
+  }
+  return cfg.authenticate(http.HandlerFunc(nextHandler))
+}
+
+// handleAdminBackup takes a snapshot of the database and
+// prunes old snapshots according to the retention policy
+func (cfg *apiConfig) handleAdminBackup (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  snapshot, pruned, err := cfg.backup()
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  type returnVals struct {
+    Snapshot database.Snapshot `json:"snapshot"`
+    Pruned []database.Snapshot `json:"pruned"`
+  }
+
+  data, err := json.Marshal(returnVals{Snapshot: snapshot, Pruned: pruned})
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+
+  w.WriteHeader(201)
+  w.Write(data)
+}
+
+// backup takes a snapshot and applies the retention policy
+func (cfg *apiConfig) backup () (database.Snapshot, []database.Snapshot, error) {
+  snapshot, err := cfg.database.Snapshot(cfg.snapshotDir)
+  if err != nil {
+    return database.Snapshot{}, nil, err
+  }
+
+  pruned, err := database.PruneSnapshots(cfg.snapshotDir, cfg.snapshotRetention)
+  if err != nil {
+    return snapshot, pruned, err
+  }
+  return snapshot, pruned, nil
+}
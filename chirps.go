@@ -2,12 +2,16 @@ package main
 
 import (
   "fmt"
+  "log"
+  "mime"
+  "time"
+  "errors"
   "strings"
   "net/http"
   "encoding/json"
   "github.com/kekekekyle/database"
   "strconv"
-  "sort"
+  "net/url"
 )
 
 func (cfg *apiConfig) handleGetChirpById (w http.ResponseWriter, r *http.Request) {
@@ -19,26 +23,14 @@ func (cfg *apiConfig) handleGetChirpById (w http.ResponseWriter, r *http.Request
     return
   }
 
-  chirps, err := cfg.database.GetChirps()
-  if err != nil {
-    w.WriteHeader(500)
-    w.Write([]byte(fmt.Sprintf("%v", err)))
-  }
-
   chirpIndex, err := strconv.Atoi(chirpId)
   if err != nil {
     w.WriteHeader(400)
     return
   }
 
-  var foundChirp database.Chirp
-  for _, chirp := range chirps {
-    if chirp.Id == chirpIndex {
-      foundChirp = chirp
-    }
-  }
-
-  if foundChirp.Id == 0 {
+  foundChirp, err := cfg.database.FindListedChirpById(chirpIndex)
+  if err != nil {
     w.WriteHeader(404)
     return 
   }
@@ -51,86 +43,187 @@ func (cfg *apiConfig) handleGetChirpById (w http.ResponseWriter, r *http.Request
   w.Write(data)
 }
 
+// maxChirpPageSize caps the limit clients can ask for
+const maxChirpPageSize = 100
+
 func (cfg *apiConfig) handleGetChirps (w http.ResponseWriter, r *http.Request) {
   w.Header().Set("Content-Type", "application/json")
-  qAuthorId := r.URL.Query().Get("author_id")
-
-  qSort := r.URL.Query().Get("sort")
+  query, errs := parseChirpQuery(r.URL.Query())
+  if len(errs) > 0 {
+    writeFilterErrors(w, errs)
+    return
+  }
+  cfg.writeChirpPage(w, r, query)
+}
 
-  chirps, err := cfg.database.GetChirps()
+// writeChirpPage writes the page of chirps query selects, with a Link
+// header pointing at the pages either side
+func (cfg *apiConfig) writeChirpPage (w http.ResponseWriter, r *http.Request, query database.ChirpQuery) {
+  page, err := cfg.database.ListChirps(query)
+  if errors.Is(err, database.ErrInvalidCursor) {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": %q}`, err.Error())))
+    return
+  }
   if err != nil {
     w.WriteHeader(500)
     w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
   }
 
-  if qSort == "asc" {
-    sort.Slice(chirps, func(i, j int) bool { return chirps[i].Id < chirps[j].Id})
+  if link := pageLinks(r.URL, page.NextCursor, page.PrevCursor); link != "" {
+    w.Header().Set("Link", link)
   }
 
-  if qSort == "desc" {
-    sort.Slice(chirps, func(i, j int) bool { return chirps[i].Id > chirps[j].Id})
+  data, err := json.Marshal(page.Chirps)
+  if err != nil {
+    w.WriteHeader(500)
   }
+  w.Write(data)
+}
 
-  if qAuthorId != "" {
-    authorId, err := strconv.Atoi(qAuthorId)
-    if err != nil {
-      w.WriteHeader(500)
-      w.Write([]byte(fmt.Sprintf("%v", err)))
-    }
-    filteredChirps := []database.Chirp{}
-    for _, chirp := range chirps {
-      if chirp.AuthorId == authorId {
-        filteredChirps = append(filteredChirps, chirp)
-      }
-    }
-    data, err := json.Marshal(filteredChirps)
-    if err != nil {
-      w.WriteHeader(500)
+// handleSearchChirps returns chirps matching the q parameter, best
+// match first, paged the same way as handleGetChirps
+func (cfg *apiConfig) handleSearchChirps (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+  qLimit := r.URL.Query().Get("limit")
+
+  query := database.SearchQuery{
+    Text: r.URL.Query().Get("q"),
+    Cursor: r.URL.Query().Get("cursor"),
+    Limit: maxChirpPageSize,
+  }
+
+  if strings.TrimSpace(query.Text) == "" {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "q is required"}`))
+    return
+  }
+
+  if qLimit != "" {
+    limit, err := strconv.Atoi(qLimit)
+    if err != nil || limit < 1 || limit > maxChirpPageSize {
+      w.WriteHeader(400)
+      w.Write([]byte(fmt.Sprintf(`{"error": "limit must be between 1 and %d"}`, maxChirpPageSize)))
+      return
     }
-    w.Write(data)
+    query.Limit = limit
+  }
+
+  page, err := cfg.database.SearchChirps(query)
+  if errors.Is(err, database.ErrInvalidCursor) || errors.Is(err, database.ErrNoSearchTerms) {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": %q}`, err.Error())))
+    return
   }
-  data, err := json.Marshal(chirps)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  if link := pageLinks(r.URL, page.NextCursor, page.PrevCursor); link != "" {
+    w.Header().Set("Link", link)
+  }
+
+  data, err := json.Marshal(page.Results)
   if err != nil {
     w.WriteHeader(500)
   }
   w.Write(data)
 }
 
+// pageLinks builds a Link header pointing at the pages either side of
+// the current one, keeping the rest of the request's query string
+func pageLinks(requestUrl *url.URL, nextCursor string, prevCursor string) string {
+  links := []string{}
+  for _, link := range []struct{ cursor, rel string }{
+    {nextCursor, "next"},
+    {prevCursor, "prev"},
+  } {
+    if link.cursor == "" {
+      continue
+    }
+    query := requestUrl.Query()
+    query.Set("cursor", link.cursor)
+    pageUrl := url.URL{Path: requestUrl.Path, RawQuery: query.Encode()}
+    links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, pageUrl.String(), link.rel))
+  }
+  return strings.Join(links, ", ")
+}
+
 type HandleCreateChirps struct {
   api *apiConfig
 }
 
-func (h *HandleCreateChirps) ServeHTTP (w http.ResponseWriter, r *http.Request) {
-  w.Header().Set("Content-Type", "application/json")
+// chirpRequest is a chirp to create. It's sent as JSON, or to attach
+// images as a multipart form with body, in_reply_to and attachments
+// fields.
+type chirpRequest struct {
+  Body string `json:"body"`
+  InReplyTo int `json:"in_reply_to"`
+  uploads []upload
+}
 
-  decoder := json.NewDecoder(r.Body)
-  chirp := database.Chirp{}
-  err := decoder.Decode(&chirp)
+// decodeChirpRequest reads a chirpRequest in either format. It responds
+// with an error and returns false if the request can't be used.
+func (cfg *apiConfig) decodeChirpRequest(w http.ResponseWriter, r *http.Request) (chirpRequest, bool) {
+  chirp := chirpRequest{}
+  mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+  if mediaType != "multipart/form-data" {
+    if err := json.NewDecoder(r.Body).Decode(&chirp); err != nil {
+      w.WriteHeader(400)
+      w.Write([]byte(`{"error": "Something went wrong"}`))
+      return chirpRequest{}, false
+    }
+    return chirp, true
+  }
 
-  if err != nil || len(chirp.Body) > 140 {
+  r.Body = http.MaxBytesReader(w, r.Body, cfg.media.maxRequestBytes())
+  if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+      w.WriteHeader(413)
+      w.Write([]byte(fmt.Sprintf(`{"error": "Request is larger than %d bytes"}`, tooLarge.Limit)))
+      return chirpRequest{}, false
+    }
     w.WriteHeader(400)
-    w.Write([]byte(`{
-      "error": "Something went wrong"
-    }`))
-    return
+    w.Write([]byte(`{"error": "Something went wrong"}`))
+    return chirpRequest{}, false
   }
+  defer r.MultipartForm.RemoveAll()
 
-  words := strings.Split(chirp.Body, " ")
-  cleanedWords := []string{}
-  for _, word := range words {
-    cleanedWord := word
-    if strings.ToLower(word) == "kerfuffle" {
-      cleanedWord = "****"
-    }
-    if strings.ToLower(word) == "sharbert" {
-      cleanedWord = "****"
-    }
-    if strings.ToLower(word) == "fornax" {
-      cleanedWord = "****"
+  chirp.Body = r.FormValue("body")
+  if value := r.FormValue("in_reply_to"); value != "" {
+    inReplyTo, err := strconv.Atoi(value)
+    if err != nil {
+      w.WriteHeader(400)
+      w.Write([]byte(`{"error": "in_reply_to must be the id of a chirp"}`))
+      return chirpRequest{}, false
     }
-    cleanedWords = append(cleanedWords, cleanedWord)
+    chirp.InReplyTo = inReplyTo
+  }
+
+  uploads, mediaErr := cfg.media.readUploads(r.MultipartForm)
+  if mediaErr != nil {
+    mediaErr.write(w)
+    return chirpRequest{}, false
+  }
+  chirp.uploads = uploads
+  return chirp, true
+}
+
+// maxMultipartMemory is how much of a multipart chirp is held in memory
+// while it's parsed; the rest is spooled to temporary files
+const maxMultipartMemory = 8 << 20
+
+func (h *HandleCreateChirps) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirp, ok := h.api.decodeChirpRequest(w, r)
+  if !ok {
+    return
   }
-  cleanedString := strings.Join(cleanedWords, " ")
 
   userHeader := r.Header.Get("User")
   user := database.User{}
@@ -140,12 +233,54 @@ func (h *HandleCreateChirps) ServeHTTP (w http.ResponseWriter, r *http.Request)
     return
   }
 
-  createdChirp, err := h.api.database.CreateChirp(cleanedString, user.Id)
+  // A chirp can be just images, so an empty body is only an error
+  // when nothing is attached
+  if len(chirp.uploads) == 0 || strings.TrimSpace(chirp.Body) != "" {
+    if !h.api.validateChirpLength(w, user, chirp.Body) {
+      return
+    }
+  }
+
+  moderated, ok := h.api.moderateChirp(w, user, "create", chirp.Body)
+  if !ok {
+    return
+  }
+
+  if chirp.InReplyTo != 0 {
+    parent, err := h.api.database.FindChirpById(chirp.InReplyTo)
+    if err != nil || !parent.IsListed() {
+      w.WriteHeader(400)
+      w.Write([]byte(`{"error": "in_reply_to must be the id of a chirp"}`))
+      return
+    }
+  }
+
+  var createdChirp database.Chirp
+  var err error
+  if len(chirp.uploads) > 0 {
+    var attachments []database.Attachment
+    attachments, err = h.api.media.storeUploads(chirp.uploads)
+    if err != nil {
+      w.WriteHeader(500)
+      w.Write([]byte(fmt.Sprintf("%v", err)))
+      return
+    }
+    createdChirp, err = h.api.database.CreateChirpWithAttachments(moderated.Body, user.Id, chirp.InReplyTo, attachments)
+    if err != nil {
+      h.api.media.deleteAttachments(attachments)
+    }
+  } else if chirp.InReplyTo != 0 {
+    createdChirp, err = h.api.database.CreateReply(moderated.Body, user.Id, chirp.InReplyTo)
+  } else {
+    createdChirp, err = h.api.database.CreateChirp(moderated.Body, user.Id)
+  }
   if err != nil {
     w.WriteHeader(500)
     w.Write([]byte(fmt.Sprintf("%v", err)))
     return
   }
+  h.api.recordModeration(moderated, createdChirp.Id, user.Id, "create", chirp.Body)
+  h.api.events.Publish(Event{Type: EventChirpCreated, ActorId: user.Id, Chirp: createdChirp})
 
   data, err := json.Marshal(createdChirp)
   if err != nil {
@@ -170,28 +305,120 @@ func (h *HandleDeleteChirps) ServeHTTP (w http.ResponseWriter, r *http.Request)
     return
   }
 
-  chirps, err := h.api.database.GetChirps()
+  chirpIndex, err := strconv.Atoi(chirpId)
   if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  foundChirp, err := h.api.database.FindChirpById(chirpIndex)
+  if err != nil || foundChirp.IsDeleted() {
+    w.WriteHeader(404)
+    return 
+  }
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
     w.WriteHeader(500)
     w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
   }
 
-  chirpIndex, err := strconv.Atoi(chirpId)
+  if foundChirp.AuthorId != user.Id && !h.api.isAdmin(user) {
+    w.WriteHeader(403)
+    return
+  }
+
+  if err = h.api.database.DeleteChirp(chirpIndex, user.Id); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  h.api.events.Publish(Event{Type: EventChirpDeleted, ActorId: user.Id, Chirp: foundChirp})
+
+  w.WriteHeader(204)
+}
+
+type HandleUndeleteChirps struct {
+  api *apiConfig
+}
+
+func (h *HandleUndeleteChirps) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirpIndex, err := strconv.Atoi(r.PathValue("chirpId"))
   if err != nil {
     w.WriteHeader(400)
     return
   }
 
-  var foundChirp database.Chirp
-  for _, chirp := range chirps {
-    if chirp.Id == chirpIndex {
-      foundChirp = chirp
-    }
+  foundChirp, err := h.api.database.FindChirpById(chirpIndex)
+  if err != nil || !foundChirp.IsDeleted() {
+    w.WriteHeader(404)
+    return
+  }
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  // Authors can only undo their own deletes, not a moderator's
+  if !h.api.isAdmin(user) && (foundChirp.AuthorId != user.Id || foundChirp.DeletedBy != user.Id) {
+    w.WriteHeader(403)
+    return
+  }
+
+  restoredChirp, err := h.api.database.UndeleteChirp(chirpIndex)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  data, err := json.Marshal(restoredChirp)
+  if err != nil {
+    w.WriteHeader(500)
+    return
   }
 
-  if foundChirp.Id == 0 {
+  w.WriteHeader(200)
+  w.Write(data)
+}
+
+type HandleEditChirps struct {
+  api *apiConfig
+}
+
+func (h *HandleEditChirps) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirpIndex, err := strconv.Atoi(r.PathValue("chirpId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  decoder := json.NewDecoder(r.Body)
+  chirp := database.Chirp{}
+  err = decoder.Decode(&chirp)
+
+  if err != nil {
+    w.WriteHeader(400)
+    w.Write([]byte(`{
+      "error": "Something went wrong"
+    }`))
+    return
+  }
+
+  foundChirp, err := h.api.database.FindChirpById(chirpIndex)
+  if err != nil || foundChirp.IsDeleted() {
     w.WriteHeader(404)
-    return 
+    return
   }
 
   userHeader := r.Header.Get("User")
@@ -204,13 +431,97 @@ func (h *HandleDeleteChirps) ServeHTTP (w http.ResponseWriter, r *http.Request)
 
   if foundChirp.AuthorId != user.Id {
     w.WriteHeader(403)
+    return
+  }
+
+  if foundChirp.RechirpOf != 0 {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "Rechirps can't be edited"}`))
+    return
+  }
+
+  if !h.api.validateChirpLength(w, user, chirp.Body) {
+    return
+  }
+
+  moderated, ok := h.api.moderateChirp(w, user, "edit", chirp.Body)
+  if !ok {
+    return
   }
 
-  if err = h.api.database.DeleteChirp(chirpIndex); err != nil {
+  editedChirp, err := h.api.database.EditChirp(chirpIndex, moderated.Body)
+  if err != nil {
     w.WriteHeader(500)
     w.Write([]byte(fmt.Sprintf("%v", err)))
     return
   }
+  h.api.recordModeration(moderated, chirpIndex, user.Id, "edit", chirp.Body)
+  h.api.events.Publish(Event{Type: EventChirpEdited, ActorId: user.Id, Chirp: editedChirp})
 
-  w.WriteHeader(204)
+  data, err := json.Marshal(editedChirp)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+
+  w.WriteHeader(200)
+  w.Write(data)
+}
+
+func (cfg *apiConfig) handleGetChirpRevisions (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirpIndex, err := strconv.Atoi(r.PathValue("chirpId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  foundChirp, err := cfg.database.FindChirpById(chirpIndex)
+  if err != nil || !foundChirp.IsListed() {
+    w.WriteHeader(404)
+    return
+  }
+
+  revisions, err := cfg.database.ListChirpRevisions(chirpIndex)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  data, err := json.Marshal(revisions)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+
+  w.Write(data)
+}
+
+// purgeDeletedChirps hard deletes tombstones older than the retention
+// period, and their attachments, every interval until stop is closed
+func (cfg *apiConfig) purgeDeletedChirps (interval time.Duration, retention time.Duration, stop <-chan struct{}) {
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-stop:
+      return
+    case <-ticker.C:
+    }
+
+    purged, err := cfg.database.PurgeChirps(time.Now().Add(-retention))
+    if err != nil {
+      log.Printf("Unable to purge deleted chirps: %v", err)
+      continue
+    }
+    for _, chirp := range purged {
+      cfg.media.deleteAttachments(chirp.Attachments)
+    }
+    if len(purged) > 0 {
+      log.Printf("Purged %d deleted chirps", len(purged))
+    }
+  }
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+  "fmt"
+  "errors"
+  "testing"
+
+  "github.com/kekekekyle/database"
+)
+
+func TestCreateAndGetChirp(t *testing.T) {
+  api := newTestAPI(t)
+  userId, token := api.signUp("author@example.com")
+
+  status, chirp := api.do("POST", "/api/chirps", token, map[string]string{"body": "Hello, world"})
+  if status != 201 {
+    t.Fatalf("Create responded %v", status)
+  }
+  if chirp["body"] != "Hello, world" || int(chirp["author_id"].(float64)) != userId {
+    t.Errorf("Created %v", chirp)
+  }
+
+  id := int(chirp["id"].(float64))
+  status, found := api.do("GET", fmt.Sprintf("/api/chirps/%d", id), "", nil)
+  if status != 200 {
+    t.Fatalf("Get responded %v", status)
+  }
+  if found["body"] != "Hello, world" {
+    t.Errorf("Got %v", found)
+  }
+
+  if status, _ := api.do("GET", "/api/chirps/999", "", nil); status != 404 {
+    t.Errorf("Get of a missing chirp responded %v", status)
+  }
+}
+
+func TestCreateChirpValidation(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("author@example.com")
+
+  long := ""
+  for len(long) <= 140 {
+    long += "a"
+  }
+  if status, _ := api.do("POST", "/api/chirps", token, map[string]string{"body": long}); status != 400 {
+    t.Errorf("Create of a %d character chirp responded %v", len(long), status)
+  }
+  if status, _ := api.do("POST", "/api/chirps", token, map[string]interface{}{"body": "Reply", "in_reply_to": 999}); status == 201 {
+    t.Error("Create of a reply to a missing chirp succeeded")
+  }
+}
+
+func TestDeleteChirp(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("author@example.com")
+  _, otherToken := api.signUp("other@example.com")
+
+  _, chirp := api.do("POST", "/api/chirps", token, map[string]string{"body": "Short lived"})
+  path := fmt.Sprintf("/api/chirps/%d", int(chirp["id"].(float64)))
+
+  if status, _ := api.do("DELETE", path, otherToken, nil); status != 403 {
+    t.Errorf("Delete by someone else responded %v", status)
+  }
+  if status, _ := api.do("DELETE", path, token, nil); status != 204 {
+    t.Fatalf("Delete responded %v", status)
+  }
+  if status, _ := api.do("GET", path, "", nil); status != 404 {
+    t.Errorf("Get of a deleted chirp responded %v", status)
+  }
+  if status, _ := api.do("DELETE", path, token, nil); status != 404 {
+    t.Errorf("Deleting twice responded %v", status)
+  }
+}
+
+// failingStore is a database whose chirp listings always fail
+type failingStore struct {
+  database.Store
+}
+
+func (failingStore) ListChirps(query database.ChirpQuery) (database.ChirpPage, error) {
+  return database.ChirpPage{}, errors.New("Disk on fire")
+}
+
+func TestListChirpsErrors(t *testing.T) {
+  api := newTestAPI(t)
+
+  if status, _ := api.send("GET", "/api/chirps?cursor=not-a-cursor", "", nil); status != 400 {
+    t.Errorf("List with a bad cursor responded %v", status)
+  }
+
+  api.cfg.database = failingStore{api.cfg.database}
+  if status, _ := api.send("GET", "/api/chirps", "", nil); status != 500 {
+    t.Errorf("List when the database fails responded %v", status)
+  }
+}
+
+func TestSearchChirpsErrors(t *testing.T) {
+  api := newTestAPI(t)
+
+  if status, _ := api.send("GET", "/api/chirps/search?q=hello&cursor=not-a-cursor", "", nil); status != 400 {
+    t.Errorf("Search with a bad cursor responded %v", status)
+  }
+  if status, _ := api.send("GET", "/api/chirps/search?q=%21%21", "", nil); status != 400 {
+    t.Errorf("Search with no words responded %v", status)
+  }
+  if status, _ := api.send("GET", "/api/chirps/search?q=hello", "", nil); status != 200 {
+    t.Errorf("Search responded %v", status)
+  }
+}
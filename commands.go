@@ -0,0 +1,52 @@
+package main
+
+import (
+  "fmt"
+  "github.com/kekekekyle/database"
+)
+
+// runCommand runs one of the maintenance subcommands against the
+// database instead of starting the server
+func (cfg *apiConfig) runCommand (args []string) error {
+  switch args[0] {
+  case "backup":
+    snapshot, pruned, err := cfg.backup()
+    if err != nil {
+      return err
+    }
+    fmt.Printf("Wrote %s (sha256 %s)\n", snapshot.Path, snapshot.Checksum)
+    for _, old := range pruned {
+      fmt.Printf("Pruned %s\n", old.Path)
+    }
+    return nil
+
+  case "restore":
+    path := ""
+    if len(args) > 1 {
+      path = args[1]
+    } else {
+      snapshots, err := database.ListSnapshots(cfg.snapshotDir)
+      if err != nil {
+        return err
+      }
+      if len(snapshots) == 0 {
+        return fmt.Errorf("No snapshots found in %s", cfg.snapshotDir)
+      }
+      path = snapshots[0].Path
+    }
+
+    if err := cfg.database.Restore(path); err != nil {
+      return err
+    }
+    fmt.Printf("Restored %s\n", path)
+    return nil
+
+  case "seed":
+    if len(args) < 2 {
+      return fmt.Errorf("Usage: seed <fixture>")
+    }
+    return cfg.seedFromFile(args[1])
+  }
+
+  return fmt.Errorf("Unknown command: %s (expected backup, restore [snapshot] or seed <fixture>)", args[0])
+}
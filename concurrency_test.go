@@ -0,0 +1,155 @@
+package main
+
+import (
+  "fmt"
+  "sync"
+  "testing"
+
+  "golang.org/x/crypto/bcrypt"
+)
+
+// These tests are meant for go test -race. They hammer the endpoints
+// from many goroutines at once and then check nothing was lost.
+
+func TestConcurrentUserWrites(t *testing.T) {
+  // A slower hash holds each update open long enough for the other
+  // writes to land in the middle of it
+  passwordCost = bcrypt.MinCost + 3
+  t.Cleanup(func() { passwordCost = bcrypt.MinCost })
+  api := newTestAPI(t)
+
+  type account struct {
+    id int
+    email string
+    refreshToken string
+  }
+  accounts := []*account{}
+  for i := 0; i < 4; i++ {
+    email := fmt.Sprintf("user%d@example.com", i)
+    id, _ := api.signUp(email)
+    accounts = append(accounts, &account{id: id, email: email})
+  }
+
+  // Each user keeps updating their profile while they log in once more
+  // and get upgraded. Whatever order the writes land in, the login's
+  // refresh token and the upgrade both have to survive.
+  var wg sync.WaitGroup
+  for _, acct := range accounts {
+    acct := acct
+    credentials := map[string]string{"email": acct.email, "password": "hunter2"}
+    _, user := api.do("POST", "/api/login", "", credentials)
+    token := user["token"].(string)
+
+    done := make(chan struct{})
+    var updating sync.WaitGroup
+    for i := 0; i < 3; i++ {
+      wg.Add(1)
+      updating.Add(1)
+      go func() {
+        defer wg.Done()
+        for first := true; ; first = false {
+          select {
+          case <-done:
+            return
+          default:
+          }
+          status, _ := api.do("PUT", "/api/users", token, credentials)
+          if first {
+            updating.Done()
+          }
+          if status != 200 {
+            t.Errorf("Update responded %v", status)
+            return
+          }
+        }
+      }()
+    }
+
+    var writes sync.WaitGroup
+    writes.Add(2)
+    go func() {
+      defer writes.Done()
+      updating.Wait()
+      status, user := api.do("POST", "/api/login", "", credentials)
+      if status != 200 {
+        t.Errorf("Login responded %v", status)
+        return
+      }
+      acct.refreshToken = user["refresh_token"].(string)
+    }()
+    go func() {
+      defer writes.Done()
+      updating.Wait()
+      upgrade := map[string]interface{}{"event": "user.upgraded", "data": map[string]int{"user_id": acct.id}}
+      if status, _ := api.doAuthorized("POST", "/api/polka/webhooks", "ApiKey test key", upgrade); status != 204 {
+        t.Errorf("Upgrade responded %v", status)
+      }
+    }()
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      writes.Wait()
+      close(done)
+    }()
+  }
+  wg.Wait()
+
+  for _, acct := range accounts {
+    if status, _ := api.do("POST", "/api/refresh", acct.refreshToken, nil); status != 200 {
+      t.Errorf("Refresh token from %v's last login was lost: refresh responded %v", acct.email, status)
+    }
+    _, user := api.do("POST", "/api/login", "", map[string]string{"email": acct.email, "password": "hunter2"})
+    if user["is_chirpy_red"] != true {
+      t.Errorf("%v's upgrade was lost", acct.email)
+    }
+  }
+}
+
+func TestConcurrentChirping(t *testing.T) {
+  api := newTestAPI(t)
+
+  const users = 4
+  const chirpsEach = 10
+  tokens := []string{}
+  for i := 0; i < users; i++ {
+    _, token := api.signUp(fmt.Sprintf("chirper%d@example.com", i))
+    tokens = append(tokens, token)
+  }
+  _, first := api.do("POST", "/api/chirps", tokens[0], map[string]string{"body": "First!"})
+  firstPath := fmt.Sprintf("/api/chirps/%d", int(first["id"].(float64)))
+
+  var wg sync.WaitGroup
+  for _, token := range tokens {
+    token := token
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for i := 0; i < chirpsEach; i++ {
+        if status, _ := api.do("POST", "/api/chirps", token, map[string]string{"body": fmt.Sprintf("Chirp %d #hammer", i)}); status != 201 {
+          t.Errorf("Create responded %v", status)
+        }
+      }
+    }()
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for i := 0; i < chirpsEach; i++ {
+        api.do("POST", firstPath+"/likes", token, nil)
+        api.do("POST", firstPath+"/rechirps", token, nil)
+        api.do("GET", "/api/chirps?sort=desc&limit=5", "", nil)
+        api.do("GET", "/api/chirps/search?q=hammer", "", nil)
+        api.do("GET", "/api/notifications", token, nil)
+      }
+    }()
+  }
+  wg.Wait()
+
+  _, chirp := api.do("GET", firstPath, "", nil)
+  if chirp["like_count"] != float64(users) || chirp["rechirp_count"] != float64(users) {
+    t.Errorf("Got %v likes and %v rechirps, expected %d of each", chirp["like_count"], chirp["rechirp_count"], users)
+  }
+
+  if tagged := api.list("/api/hashtags/hammer/chirps?limit=100"); len(tagged) != users*chirpsEach {
+    t.Errorf("Found %d chirps tagged #hammer, expected %d", len(tagged), users*chirpsEach)
+  }
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+  "fmt"
+  "errors"
+  "strconv"
+  "strings"
+  "net/http"
+  "encoding/json"
+
+  "github.com/kekekekyle/database"
+)
+
+// maxMessageLength caps a direct message, counted the same way as chirps
+const maxMessageLength = 1000
+
+// parsePageLimit reads the limit query parameter, defaulting to
+// maxChirpPageSize. It responds 400 and returns false if it's invalid.
+func parsePageLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+  qLimit := r.URL.Query().Get("limit")
+  if qLimit == "" {
+    return maxChirpPageSize, true
+  }
+  limit, err := strconv.Atoi(qLimit)
+  if err != nil || limit < 1 || limit > maxChirpPageSize {
+    writeFilterErrors(w, []filterError{{
+      Param: "limit",
+      Value: qLimit,
+      Message: fmt.Sprintf("must be between 1 and %d", maxChirpPageSize),
+    }})
+    return 0, false
+  }
+  return limit, true
+}
+
+type HandleConversations struct {
+  api *apiConfig
+}
+
+// ServeHTTP starts a conversation with the user in the body, or returns
+// the one the two of them already have
+func (h *HandleConversations) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  type parameters struct {
+    UserId int `json:"user_id"`
+  }
+  params := parameters{}
+  if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "Something went wrong"}`))
+    return
+  }
+  if params.UserId == user.Id {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "You can't start a conversation with yourself"}`))
+    return
+  }
+  if _, err := h.api.database.FindUserById(params.UserId); err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  conversation, err := h.api.database.CreateConversation(user.Id, params.UserId)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  data, err := json.Marshal(conversation)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.WriteHeader(201)
+  w.Write(data)
+}
+
+type HandleListConversations struct {
+  api *apiConfig
+}
+
+// ServeHTTP returns a page of the user's conversations, most recently
+// active first, with unread counts
+func (h *HandleListConversations) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  limit, ok := parsePageLimit(w, r)
+  if !ok {
+    return
+  }
+
+  page, err := h.api.database.ListConversations(user.Id, limit, r.URL.Query().Get("cursor"))
+  if errors.Is(err, database.ErrInvalidCursor) {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": %q}`, err.Error())))
+    return
+  }
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  if link := pageLinks(r.URL, page.NextCursor, page.PrevCursor); link != "" {
+    w.Header().Set("Link", link)
+  }
+  data, err := json.Marshal(page.Conversations)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
+
+type HandleGetConversation struct {
+  api *apiConfig
+}
+
+func (h *HandleGetConversation) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  conversationId, err := strconv.Atoi(r.PathValue("conversationId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  conversation, err := h.api.database.GetConversation(conversationId, user.Id)
+  if err != nil {
+    w.WriteHeader(404)
+    return
+  }
+  data, err := json.Marshal(conversation)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
+
+type HandleSendMessages struct {
+  api *apiConfig
+}
+
+func (h *HandleSendMessages) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  conversationId, err := strconv.Atoi(r.PathValue("conversationId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  type parameters struct {
+    Body string `json:"body"`
+  }
+  params := parameters{}
+  if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "Something went wrong"}`))
+    return
+  }
+
+  conversation, err := h.api.database.GetConversation(conversationId, user.Id)
+  if err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  if strings.TrimSpace(params.Body) == "" {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "Message is empty"}`))
+    return
+  }
+  if length := h.api.chirpLength.measure(params.Body); length > maxMessageLength {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": "Message is too long: %d %s, the limit is %d"}`, length, h.api.chirpLength.unit, maxMessageLength)))
+    return
+  }
+
+  // Messages are private, so unlike chirps what moderation finds in
+  // them isn't recorded for admins to review
+  moderated := h.api.moderation.Moderate(params.Body)
+  if moderated.Rejected {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "Message was rejected by moderation"}`))
+    return
+  }
+
+  message, err := h.api.database.SendMessage(conversationId, user.Id, moderated.Body)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  h.api.events.Publish(Event{Type: EventMessageSent, ActorId: user.Id, Conversation: conversation, Message: message})
+  data, err := json.Marshal(message)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.WriteHeader(201)
+  w.Write(data)
+}
+
+type HandleListMessages struct {
+  api *apiConfig
+}
+
+// ServeHTTP returns a page of a conversation's messages, newest first
+func (h *HandleListMessages) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  conversationId, err := strconv.Atoi(r.PathValue("conversationId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  limit, ok := parsePageLimit(w, r)
+  if !ok {
+    return
+  }
+
+  if _, err := h.api.database.GetConversation(conversationId, user.Id); err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  page, err := h.api.database.ListMessages(database.MessageQuery{
+    ConversationId: conversationId,
+    UserId: user.Id,
+    Limit: limit,
+    Cursor: r.URL.Query().Get("cursor"),
+  })
+  if errors.Is(err, database.ErrInvalidCursor) {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": %q}`, err.Error())))
+    return
+  }
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  if link := pageLinks(r.URL, page.NextCursor, page.PrevCursor); link != "" {
+    w.Header().Set("Link", link)
+  }
+  data, err := json.Marshal(page.Messages)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
+
+type HandleReadConversations struct {
+  api *apiConfig
+}
+
+// ServeHTTP marks the conversation read up to message_id, or up to the
+// newest message if the body doesn't give one
+func (h *HandleReadConversations) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  conversationId, err := strconv.Atoi(r.PathValue("conversationId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  type parameters struct {
+    MessageId int `json:"message_id"`
+  }
+  params := parameters{}
+  if r.ContentLength != 0 {
+    if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+      w.WriteHeader(400)
+      w.Write([]byte(`{"error": "Something went wrong"}`))
+      return
+    }
+  }
+
+  if _, err := h.api.database.GetConversation(conversationId, user.Id); err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  conversation, err := h.api.database.MarkConversationRead(conversationId, user.Id, params.MessageId)
+  if err != nil {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": %q}`, err.Error())))
+    return
+  }
+  data, err := json.Marshal(conversation)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+  "fmt"
+  "testing"
+)
+
+func TestConversationListsBadCursor(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("sender@example.com")
+  otherId, _ := api.signUp("recipient@example.com")
+
+  status, conversation := api.do("POST", "/api/conversations", token, map[string]interface{}{"user_id": otherId})
+  if status != 201 {
+    t.Fatalf("Starting a conversation responded %v", status)
+  }
+  messagesPath := fmt.Sprintf("/api/conversations/%d/messages", int(conversation["id"].(float64)))
+
+  for _, path := range []string{"/api/conversations", messagesPath} {
+    if status, _ := api.do("GET", path+"?cursor=not-a-cursor", token, nil); status != 400 {
+      t.Errorf("GET %v with a bad cursor responded %v", path, status)
+    }
+    if status, _ := api.do("GET", path, token, nil); status != 200 {
+      t.Errorf("GET %v responded %v", path, status)
+    }
+  }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+  "strconv"
+  "net/http"
+  "github.com/kekekekyle/database"
+)
+
+// handleGetHashtagChirps lists the chirps using a hashtag. It takes the
+// same query parameters as handleGetChirps.
+func (cfg *apiConfig) handleGetHashtagChirps (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  tag := database.NormalizeHashtag(r.PathValue("tag"))
+  if tag == "" {
+    w.WriteHeader(404)
+    return
+  }
+
+  query, errs := parseChirpQuery(r.URL.Query())
+  if len(errs) > 0 {
+    writeFilterErrors(w, errs)
+    return
+  }
+  query.Hashtag = tag
+  cfg.writeChirpPage(w, r, query)
+}
+
+// handleGetUserMentions lists the chirps mentioning a user. It takes the
+// same query parameters as handleGetChirps.
+func (cfg *apiConfig) handleGetUserMentions (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  userId, err := strconv.Atoi(r.PathValue("userId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  if _, err := cfg.database.FindUserById(userId); err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  query, errs := parseChirpQuery(r.URL.Query())
+  if len(errs) > 0 {
+    writeFilterErrors(w, errs)
+    return
+  }
+  query.MentionedUserId = userId
+  cfg.writeChirpPage(w, r, query)
+}
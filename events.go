@@ -0,0 +1,67 @@
+package main
+
+import (
+  "sync"
+
+  "github.com/kekekekyle/database"
+)
+
+// Event types published on the event bus
+const (
+  EventChirpCreated = "chirp.created"
+  EventChirpEdited = "chirp.edited"
+  EventChirpDeleted = "chirp.deleted"
+  EventChirpModerated = "chirp.moderated"
+  EventChirpLiked = "chirp.liked"
+  EventChirpRechirped = "chirp.rechirped"
+  EventUserFollowed = "user.followed"
+  EventMessageSent = "message.sent"
+)
+
+// Event is something a user did that other parts of the server may
+// react to. Only the fields that make sense for its type are set.
+type Event struct {
+  Type string
+  // ActorId is the user who did it
+  ActorId int
+  // Chirp is the chirp acted on, as it was after the action
+  Chirp database.Chirp
+  // UserId is the user acted on, such as the one followed
+  UserId int
+  Conversation database.Conversation
+  Message database.Message
+  // Action and Note describe a moderation action
+  Action string
+  Note string
+}
+
+// eventBus passes events from the handlers that cause them to whoever
+// subscribed to their type
+type eventBus struct {
+  mux sync.RWMutex
+  subscribers map[string][]func(Event)
+}
+
+func newEventBus() *eventBus {
+  return &eventBus{subscribers: map[string][]func(Event){}}
+}
+
+// Subscribe calls handler with every event of the given type
+func (b *eventBus) Subscribe(eventType string, handler func(Event)) {
+  b.mux.Lock()
+  defer b.mux.Unlock()
+  b.subscribers[eventType] = append(b.subscribers[eventType], handler)
+}
+
+// Publish calls each subscriber to the event's type in the order they
+// subscribed, before returning. Subscribers that do slow work should
+// hand it off rather than hold up the request publishing the event.
+func (b *eventBus) Publish(event Event) {
+  b.mux.RLock()
+  handlers := b.subscribers[event.Type]
+  b.mux.RUnlock()
+
+  for _, handler := range handlers {
+    handler(event)
+  }
+}
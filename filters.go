@@ -0,0 +1,137 @@
+package main
+
+import (
+  "fmt"
+  "time"
+  "strings"
+  "strconv"
+  "net/url"
+  "encoding/json"
+  "net/http"
+
+  "github.com/kekekekyle/database"
+)
+
+// filterError describes one query parameter that couldn't be used
+type filterError struct {
+  Param string `json:"param"`
+  Value string `json:"value"`
+  Message string `json:"message"`
+}
+
+// writeFilterErrors responds 400 with every problem found in the query
+func writeFilterErrors(w http.ResponseWriter, errs []filterError) {
+  data, err := json.Marshal(struct {
+    Error string `json:"error"`
+    Filters []filterError `json:"filters"`
+  }{"Invalid query parameters", errs})
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.WriteHeader(400)
+  w.Write(data)
+}
+
+// queryValues returns every value given for param, splitting
+// comma separated lists and dropping empty entries
+func queryValues(values url.Values, param string) []string {
+  result := []string{}
+  for _, value := range values[param] {
+    for _, part := range strings.Split(value, ",") {
+      if part = strings.TrimSpace(part); part != "" {
+        result = append(result, part)
+      }
+    }
+  }
+  return result
+}
+
+// parseChirpQuery turns the query string of GET /api/chirps into a
+// ChirpQuery, collecting an error for each parameter it can't use.
+//
+//   author_id   one or more author ids, repeated or comma separated
+//   since/until RFC 3339 times bounding created_at; until is exclusive
+//   min_id/max_id inclusive id bounds
+//   contains/excludes words the body must or mustn't contain; repeat
+//               the parameter for more than one
+//   sort        asc or desc
+//   limit       page size, 1 to maxChirpPageSize, which is the default
+//   cursor      from the Link header of an earlier page
+func parseChirpQuery(values url.Values) (database.ChirpQuery, []filterError) {
+  query := database.ChirpQuery{
+    Cursor: values.Get("cursor"),
+    Contains: values["contains"],
+    Excludes: values["excludes"],
+  }
+  errs := []filterError{}
+  invalid := func(param string, value string, message string) {
+    errs = append(errs, filterError{Param: param, Value: value, Message: message})
+  }
+
+  for _, value := range queryValues(values, "author_id") {
+    authorId, err := strconv.Atoi(value)
+    if err != nil || authorId < 1 {
+      invalid("author_id", value, "must be a positive integer")
+      continue
+    }
+    query.AuthorIds = append(query.AuthorIds, authorId)
+  }
+
+  parseTime := func(param string) time.Time {
+    value := values.Get(param)
+    if value == "" {
+      return time.Time{}
+    }
+    parsed, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+      invalid(param, value, "must be an RFC 3339 time like 2024-01-02T15:04:05Z")
+    }
+    return parsed
+  }
+  query.Since = parseTime("since")
+  query.Until = parseTime("until")
+  if !query.Since.IsZero() && !query.Until.IsZero() && !query.Since.Before(query.Until) {
+    invalid("until", values.Get("until"), "must be after since")
+  }
+
+  parseInt := func(param string, min int, max int, message string) int {
+    value := values.Get(param)
+    if value == "" {
+      return 0
+    }
+    parsed, err := strconv.Atoi(value)
+    if err != nil || parsed < min || (max > 0 && parsed > max) {
+      invalid(param, value, message)
+      return 0
+    }
+    return parsed
+  }
+  query.MinId = parseInt("min_id", 1, 0, "must be a positive integer")
+  query.MaxId = parseInt("max_id", 1, 0, "must be a positive integer")
+  if query.MinId != 0 && query.MaxId != 0 && query.MinId > query.MaxId {
+    invalid("max_id", values.Get("max_id"), "must not be less than min_id")
+  }
+  query.Limit = parseInt("limit", 1, maxChirpPageSize, fmt.Sprintf("must be between 1 and %d", maxChirpPageSize))
+  if query.Limit == 0 {
+    query.Limit = maxChirpPageSize
+  }
+
+  for _, param := range []string{"contains", "excludes"} {
+    for _, value := range values[param] {
+      if strings.TrimSpace(value) == "" {
+        invalid(param, value, "must not be empty")
+      }
+    }
+  }
+
+  switch sort := values.Get("sort"); sort {
+  case "", "asc":
+  case "desc":
+    query.Descending = true
+  default:
+    invalid("sort", sort, "must be asc or desc")
+  }
+
+  return query, errs
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+  "fmt"
+  "time"
+  "errors"
+  "strconv"
+  "net/http"
+  "encoding/json"
+
+  "github.com/kekekekyle/database"
+)
+
+// publicUser is what other users get to see of a user. It leaves out
+// the email, as follower lists are served to anyone.
+type publicUser struct {
+  Id int `json:"id"`
+  IsChirpyRed bool `json:"is_chirpy_red"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+type HandleFollowUsers struct {
+  api *apiConfig
+}
+
+func (h *HandleFollowUsers) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  followeeId, err := strconv.Atoi(r.PathValue("userId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  if followeeId == user.Id {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "You can't follow yourself"}`))
+    return
+  }
+  if _, err := h.api.database.FindUserById(followeeId); err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  follow, err := h.api.database.FollowUser(user.Id, followeeId)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  h.api.events.Publish(Event{Type: EventUserFollowed, ActorId: user.Id, UserId: followeeId})
+
+  data, err := json.Marshal(follow)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
+
+type HandleUnfollowUsers struct {
+  api *apiConfig
+}
+
+func (h *HandleUnfollowUsers) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  followeeId, err := strconv.Atoi(r.PathValue("userId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  if err := h.api.database.UnfollowUser(user.Id, followeeId); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  w.WriteHeader(204)
+}
+
+// handleFollowList serves a user's followers or the users they follow
+func (cfg *apiConfig) handleFollowList (list func(userId int) ([]database.User, error)) http.HandlerFunc {
+  return func (w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    userId, err := strconv.Atoi(r.PathValue("userId"))
+    if err != nil {
+      w.WriteHeader(400)
+      return
+    }
+
+    users, err := list(userId)
+    if err != nil {
+      w.WriteHeader(404)
+      return
+    }
+
+    publicUsers := make([]publicUser, 0, len(users))
+    for _, user := range users {
+      publicUsers = append(publicUsers, publicUser{
+        Id: user.Id,
+        IsChirpyRed: user.IsChirpyRed,
+        CreatedAt: user.CreatedAt,
+      })
+    }
+
+    data, err := json.Marshal(publicUsers)
+    if err != nil {
+      w.WriteHeader(500)
+      return
+    }
+    w.Write(data)
+  }
+}
+
+type HandleTimeline struct {
+  api *apiConfig
+}
+
+// ServeHTTP returns a page of the user's home timeline, newest first,
+// paged with cursors in the Link header like GET /api/chirps
+func (h *HandleTimeline) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  query := database.TimelineQuery{
+    UserId: user.Id,
+    Limit: maxChirpPageSize,
+    Cursor: r.URL.Query().Get("cursor"),
+  }
+  if qLimit := r.URL.Query().Get("limit"); qLimit != "" {
+    limit, err := strconv.Atoi(qLimit)
+    if err != nil || limit < 1 || limit > maxChirpPageSize {
+      writeFilterErrors(w, []filterError{{
+        Param: "limit",
+        Value: qLimit,
+        Message: fmt.Sprintf("must be between 1 and %d", maxChirpPageSize),
+      }})
+      return
+    }
+    query.Limit = limit
+  }
+
+  page, err := h.api.database.Timeline(query)
+  if errors.Is(err, database.ErrInvalidCursor) {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": %q}`, err.Error())))
+    return
+  }
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  if link := pageLinks(r.URL, page.NextCursor, page.PrevCursor); link != "" {
+    w.Header().Set("Link", link)
+  }
+
+  data, err := json.Marshal(page.Chirps)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+)
+
+func TestFollowListsHideEmails(t *testing.T) {
+  api := newTestAPI(t)
+  userId, token := api.signUp("follower@example.com")
+  followeeId, followeeToken := api.signUp("followee@example.com")
+
+  if status, _ := api.do("POST", fmt.Sprintf("/api/users/%d/follow", followeeId), token, nil); status != 200 {
+    t.Fatalf("Follow responded %v", status)
+  }
+  if status, _ := api.do("POST", fmt.Sprintf("/api/users/%d/follow", userId), followeeToken, nil); status != 200 {
+    t.Fatalf("Follow back responded %v", status)
+  }
+
+  paths := []string{
+    fmt.Sprintf("/api/users/%d/followers", followeeId),
+    fmt.Sprintf("/api/users/%d/following", followeeId),
+  }
+  for _, path := range paths {
+    users := api.list(path)
+    if len(users) != 1 || int(users[0]["id"].(float64)) != userId {
+      t.Errorf("GET %v listed %v, expected user %v", path, users, userId)
+    }
+    for _, user := range users {
+      if _, ok := user["email"]; ok {
+        t.Errorf("GET %v listed an email: %v", path, user)
+      }
+    }
+
+    _, data := api.send("GET", path, "", nil)
+    if strings.Contains(string(data), "@example.com") {
+      t.Errorf("GET %v leaked an email: %s", path, data)
+    }
+  }
+}
+
+func TestTimelineBadCursor(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("reader@example.com")
+
+  if status, _ := api.do("GET", "/api/timeline?cursor=not-a-cursor", token, nil); status != 400 {
+    t.Errorf("Timeline with a bad cursor responded %v", status)
+  }
+  if status, _ := api.do("GET", "/api/timeline", token, nil); status != 200 {
+    t.Errorf("Timeline responded %v", status)
+  }
+}
@@ -2,12 +2,40 @@ module github.com/kekekekyle/go-server
 
 go 1.23.0
 
+replace github.com/kekekekyle/blobs => ./internal/blobs
+
 replace github.com/kekekekyle/database => ./internal/database
 
+replace github.com/kekekekyle/moderation => ./internal/moderation
+
+replace github.com/kekekekyle/previews => ./internal/previews
+
 require (
-  github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1
 	github.com/joho/godotenv v1.5.1
+	github.com/kekekekyle/blobs v0.0.0
 	github.com/kekekekyle/database v0.0.0
+	github.com/kekekekyle/moderation v0.0.0
+	github.com/kekekekyle/previews v0.0.0
+	github.com/rivo/uniseg v0.4.7
 	golang.org/x/crypto v0.26.0
 )
 
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+	modernc.org/sqlite v1.34.1 // indirect
+	modernc.org/strutil v1.2.0 // indirect
+	modernc.org/token v1.1.0 // indirect
+)
@@ -0,0 +1,36 @@
+package blobs
+
+import (
+  "io"
+  "io/fs"
+  "strings"
+  "fmt"
+)
+
+// Store keeps blobs under slash separated keys. Its methods follow the
+// object operations S3 compatible services offer, so a bucket can back
+// it as well as a local directory: Put and Delete are PutObject and
+// DeleteObject, and Open is GetObject through io/fs, which lets
+// http.FS serve a Store the way http.Dir serves a directory.
+type Store interface {
+  fs.FS
+  // Put stores body under key, replacing anything already there
+  Put(key string, body io.Reader, contentType string) error
+  // Delete removes the blob under key. Deleting a missing key isn't
+  // an error.
+  Delete(key string) error
+}
+
+// ValidKey reports whether key can name a blob. Keys are relative
+// slash separated paths without . or .. elements, as fs.ValidPath
+// requires, and can't be empty or name a directory.
+func ValidKey(key string) bool {
+  return key != "." && fs.ValidPath(key) && !strings.HasSuffix(key, "/")
+}
+
+func checkKey(key string) error {
+  if !ValidKey(key) {
+    return fmt.Errorf("Invalid blob key: %q", key)
+  }
+  return nil
+}
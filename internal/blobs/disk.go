@@ -0,0 +1,86 @@
+package blobs
+
+import (
+  "io"
+  "os"
+  "io/fs"
+  "path/filepath"
+)
+
+// Disk stores blobs as files under a directory. The content type
+// isn't kept, so keys should end in an extension that implies it.
+type Disk struct {
+  root string
+  files fs.FS
+}
+
+// NewDisk returns a Disk storing blobs under root, creating it if needed
+func NewDisk(root string) (*Disk, error) {
+  if err := os.MkdirAll(root, 0755); err != nil {
+    return nil, err
+  }
+  return &Disk{root: root, files: os.DirFS(root)}, nil
+}
+
+// Put writes body to a temporary file and renames it into place, so a
+// failed upload never leaves a partial blob under key
+func (d *Disk) Put(key string, body io.Reader, contentType string) error {
+  if err := checkKey(key); err != nil {
+    return err
+  }
+
+  path := filepath.Join(d.root, filepath.FromSlash(key))
+  if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+    return err
+  }
+  file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(file.Name())
+
+  if _, err := io.Copy(file, body); err != nil {
+    file.Close()
+    return err
+  }
+  if err := file.Close(); err != nil {
+    return err
+  }
+  if err := os.Chmod(file.Name(), 0644); err != nil {
+    return err
+  }
+  return os.Rename(file.Name(), path)
+}
+
+func (d *Disk) Delete(key string) error {
+  if err := checkKey(key); err != nil {
+    return err
+  }
+  err := os.Remove(filepath.Join(d.root, filepath.FromSlash(key)))
+  if err != nil && !os.IsNotExist(err) {
+    return err
+  }
+  return nil
+}
+
+// Open opens the blob under name. Directories and files that are
+// still being uploaded aren't blobs, so they can't be opened.
+func (d *Disk) Open(name string) (fs.File, error) {
+  if !ValidKey(name) || filepath.Base(name)[0] == '.' {
+    return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+  }
+  file, err := d.files.Open(name)
+  if err != nil {
+    return nil, err
+  }
+  info, err := file.Stat()
+  if err != nil {
+    file.Close()
+    return nil, err
+  }
+  if info.IsDir() {
+    file.Close()
+    return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+  }
+  return file, nil
+}
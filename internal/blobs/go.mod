@@ -0,0 +1,3 @@
+module github.com/kekekekyle/blobs
+
+go 1.23.0
@@ -0,0 +1,35 @@
+package database
+
+import (
+  "fmt"
+)
+
+// Attachment describes a media file attached to a chirp. The file is
+// kept in a blob store under Key and fetched by clients from Url.
+type Attachment struct {
+  Key string `json:"key"`
+  Url string `json:"url"`
+  ContentType string `json:"content_type"`
+  Size int64 `json:"size"`
+  Width int `json:"width"`
+  Height int `json:"height"`
+}
+
+// FindChirpByAttachment returns the chirp a blob is attached to,
+// including one that has been deleted. Callers decide whether its
+// attachments should still be served.
+func (db *DB) FindChirpByAttachment(key string) (Chirp, error) {
+  var foundChirp Chirp
+  err := db.View(func(dbStructure *DBStructure) error {
+    id, ok := dbStructure.indexes.chirpsByAttachment[key]
+    if !ok {
+      return fmt.Errorf("No chirp found with attachment: %v", key)
+    }
+    foundChirp = dbStructure.expand(dbStructure.Chirps[id])
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, err
+  }
+  return foundChirp, nil
+}
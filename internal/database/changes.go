@@ -0,0 +1,198 @@
+package database
+
+import (
+  "sort"
+  "strconv"
+  "encoding/json"
+)
+
+// tx records the writes an update makes to a DBStructure, so the update
+// can be journaled, written out and indexed by what it changed rather
+// than by how big the database is, and undone if it fails
+type tx struct {
+  changes map[changeKey]*change
+  // order holds the changes in the order their records were first written
+  order []*change
+  // sequences holds the value each changed id counter had before
+  sequences map[string]sequenceChange
+  undo []func()
+}
+
+type changeKey struct {
+  table string
+  id int
+}
+
+// change is one record an update wrote, with the record it replaced.
+// before and after hold the table's record type.
+type change struct {
+  table string
+  id int
+  before interface{}
+  existed bool
+  after interface{}
+  exists bool
+}
+
+type sequenceChange struct {
+  before int
+  existed bool
+}
+
+func newTx() *tx {
+  return &tx{
+    changes: map[changeKey]*change{},
+    order: []*change{},
+    sequences: map[string]sequenceChange{},
+  }
+}
+
+// empty reports whether nothing was written
+func (t *tx) empty() bool {
+  return len(t.order) == 0 && len(t.sequences) == 0
+}
+
+// rollback puts back every record and counter the update changed
+func (t *tx) rollback(s *DBStructure) {
+  for i := len(t.undo) - 1; i >= 0; i-- {
+    t.undo[i]()
+  }
+  for name, sequence := range t.sequences {
+    if sequence.existed {
+      s.Sequences[name] = sequence.before
+    } else {
+      delete(s.Sequences, name)
+    }
+  }
+}
+
+// journalChanges encodes what the update changed, leaving out records
+// it created and then removed again
+func (t *tx) journalChanges(s *DBStructure) ([]journalChange, error) {
+  changes := make([]journalChange, 0, len(t.order)+len(t.sequences))
+  for _, c := range t.order {
+    if !c.existed && !c.exists {
+      continue
+    }
+    encoded := journalChange{Table: c.table, Id: strconv.Itoa(c.id)}
+    if c.exists {
+      data, err := json.Marshal(c.after)
+      if err != nil {
+        return nil, err
+      }
+      encoded.Data = data
+    } else {
+      encoded.Delete = true
+    }
+    changes = append(changes, encoded)
+  }
+
+  names := make([]string, 0, len(t.sequences))
+  for name := range t.sequences {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    changes = append(changes, journalChange{
+      Table: "sequences",
+      Id: name,
+      Data: json.RawMessage(strconv.Itoa(s.Sequences[name])),
+    })
+  }
+  return changes, nil
+}
+
+// table is a collection in DBStructure. Writing records through it
+// rather than to the map directly lets an update track what changed.
+// Outside an update, as in migrations, it just writes to the map.
+type table[T interface{}] struct {
+  name string
+  records func(s *DBStructure) map[int]T
+}
+
+var (
+  chirpsTable = table[Chirp]{"chirps", func(s *DBStructure) map[int]Chirp { return s.Chirps }}
+  usersTable = table[User]{"users", func(s *DBStructure) map[int]User { return s.Users }}
+  revisionsTable = table[ChirpRevision]{"revisions", func(s *DBStructure) map[int]ChirpRevision { return s.Revisions }}
+  likesTable = table[Like]{"likes", func(s *DBStructure) map[int]Like { return s.Likes }}
+  followsTable = table[Follow]{"follows", func(s *DBStructure) map[int]Follow { return s.Follows }}
+  conversationsTable = table[Conversation]{"conversations", func(s *DBStructure) map[int]Conversation { return s.Conversations }}
+  messagesTable = table[Message]{"messages", func(s *DBStructure) map[int]Message { return s.Messages }}
+  readReceiptsTable = table[ReadReceipt]{"read_receipts", func(s *DBStructure) map[int]ReadReceipt { return s.ReadReceipts }}
+  moderationResultsTable = table[ModerationResult]{"moderation_results", func(s *DBStructure) map[int]ModerationResult { return s.ModerationResults }}
+  reportsTable = table[Report]{"reports", func(s *DBStructure) map[int]Report { return s.Reports }}
+  moderationLogTable = table[ModerationLogEntry]{"moderation_log", func(s *DBStructure) map[int]ModerationLogEntry { return s.ModerationLog }}
+  notificationsTable = table[Notification]{"notifications", func(s *DBStructure) map[int]Notification { return s.Notifications }}
+  linkPreviewsTable = table[LinkPreview]{"link_previews", func(s *DBStructure) map[int]LinkPreview { return s.LinkPreviews }}
+)
+
+// put stores record under id
+func (t table[T]) put(s *DBStructure, id int, record T) {
+  records := t.records(s)
+  if s.tx != nil {
+    c := t.track(s.tx, records, id)
+    c.after = record
+    c.exists = true
+  }
+  records[id] = record
+}
+
+// remove deletes the record stored under id, if there is one
+func (t table[T]) remove(s *DBStructure, id int) {
+  records := t.records(s)
+  if _, ok := records[id]; !ok {
+    return
+  }
+  if s.tx != nil {
+    c := t.track(s.tx, records, id)
+    var zero T
+    c.after = zero
+    c.exists = false
+  }
+  delete(records, id)
+}
+
+// track returns the change for a record, recording what it held before
+// the first time the update writes it
+func (t table[T]) track(tx *tx, records map[int]T, id int) *change {
+  key := changeKey{t.name, id}
+  if c, ok := tx.changes[key]; ok {
+    return c
+  }
+
+  before, existed := records[id]
+  c := &change{table: t.name, id: id, before: before, existed: existed}
+  tx.changes[key] = c
+  tx.order = append(tx.order, c)
+  tx.undo = append(tx.undo, func() {
+    if existed {
+      records[id] = before
+    } else {
+      delete(records, id)
+    }
+  })
+  return c
+}
+
+// touchSequence makes sure the id counters exist and, inside an update,
+// records a counter's value before it first changes
+func (s *DBStructure) touchSequence(collection string) {
+  if s.Sequences == nil {
+    s.Sequences = map[string]int{}
+  }
+  if s.tx == nil {
+    return
+  }
+  if _, ok := s.tx.sequences[collection]; ok {
+    return
+  }
+  before, existed := s.Sequences[collection]
+  s.tx.sequences[collection] = sequenceChange{before: before, existed: existed}
+}
+
+// PutUser stores user under its id. Functions passed to Update must
+// write records through methods like this one rather than assigning
+// to the maps, or the change won't be saved.
+func (s *DBStructure) PutUser(user User) {
+  usersTable.put(s, user.Id, user)
+}
@@ -0,0 +1,381 @@
+package database
+
+import (
+  "fmt"
+  "sort"
+  "time"
+  "encoding/json"
+  "encoding/base64"
+)
+
+// Conversation is a private conversation between two users
+type Conversation struct {
+  Id int `json:"id"`
+  // ParticipantIds holds both users' ids, lowest first
+  ParticipantIds []int `json:"participant_ids"`
+  CreatedAt time.Time `json:"created_at"`
+  // LastMessage, UnreadCount and ReadReceipts are filled in for the
+  // participant reading the conversation and aren't stored
+  LastMessage *Message `json:"last_message,omitempty"`
+  UnreadCount int `json:"unread_count"`
+  ReadReceipts []ReadReceipt `json:"read_receipts"`
+}
+
+// Message is one message in a conversation
+type Message struct {
+  Id int `json:"id"`
+  ConversationId int `json:"conversation_id"`
+  SenderId int `json:"sender_id"`
+  Body string `json:"body"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+// ReadReceipt records how far a participant has read a conversation
+type ReadReceipt struct {
+  Id int `json:"id"`
+  ConversationId int `json:"conversation_id"`
+  UserId int `json:"user_id"`
+  // MessageId is the newest message the user has read
+  MessageId int `json:"message_id"`
+  ReadAt time.Time `json:"read_at"`
+}
+
+// MessageQuery selects a page of a conversation's messages, newest first
+type MessageQuery struct {
+  ConversationId int
+  // UserId is the participant asking
+  UserId int
+  // Limit is the page size. Zero returns everything after the cursor.
+  Limit int
+  // Cursor continues from a NextCursor or PrevCursor of an earlier page
+  Cursor string
+}
+
+// MessagePage is one page of messages
+type MessagePage struct {
+  Messages []Message
+  NextCursor string
+  PrevCursor string
+}
+
+// ConversationPage is one page of a user's conversations
+type ConversationPage struct {
+  Conversations []Conversation
+  NextCursor string
+  PrevCursor string
+}
+
+// pairKey identifies the conversation between two users
+func pairKey(userId int, otherId int) [2]int {
+  if userId > otherId {
+    userId, otherId = otherId, userId
+  }
+  return [2]int{userId, otherId}
+}
+
+// conversationFor returns a conversation if userId takes part in it.
+// Anyone else is told it doesn't exist.
+func (s *DBStructure) conversationFor(conversationId int, userId int) (Conversation, error) {
+  conversation, ok := s.Conversations[conversationId]
+  if ok {
+    for _, participantId := range conversation.ParticipantIds {
+      if participantId == userId {
+        return conversation, nil
+      }
+    }
+  }
+  return Conversation{}, fmt.Errorf("No conversation found with id: %v", conversationId)
+}
+
+// expandConversation fills in the fields a conversation is returned
+// to userId with
+func (s *DBStructure) expandConversation(conversation Conversation, userId int) Conversation {
+  messageIds := s.indexes.messagesByConversation[conversation.Id]
+  conversation.LastMessage = nil
+  if len(messageIds) > 0 {
+    last := s.Messages[messageIds[len(messageIds)-1]]
+    conversation.LastMessage = &last
+  }
+
+  conversation.ReadReceipts = []ReadReceipt{}
+  readUpTo := 0
+  for _, participantId := range conversation.ParticipantIds {
+    receiptId, ok := s.indexes.readReceipts[conversation.Id][participantId]
+    if !ok {
+      continue
+    }
+    receipt := s.ReadReceipts[receiptId]
+    conversation.ReadReceipts = append(conversation.ReadReceipts, receipt)
+    if participantId == userId {
+      readUpTo = receipt.MessageId
+    }
+  }
+
+  conversation.UnreadCount = 0
+  for _, messageId := range messageIds[sort.SearchInts(messageIds, readUpTo+1):] {
+    if s.Messages[messageId].SenderId != userId {
+      conversation.UnreadCount++
+    }
+  }
+  return conversation
+}
+
+// markRead moves userId's read receipt up to messageId
+func (s *DBStructure) markRead(conversationId int, userId int, messageId int, now time.Time) {
+  receipt := ReadReceipt{
+    ConversationId: conversationId,
+    UserId: userId,
+  }
+  if receiptId, ok := s.indexes.readReceipts[conversationId][userId]; ok {
+    receipt = s.ReadReceipts[receiptId]
+    if receipt.MessageId >= messageId {
+      return
+    }
+  } else {
+    receipt.Id = s.nextId("read_receipts")
+  }
+  receipt.MessageId = messageId
+  receipt.ReadAt = now
+  readReceiptsTable.put(s, receipt.Id, receipt)
+}
+
+// CreateConversation starts a conversation between userId and otherId,
+// or returns the one they already have
+func (db *DB) CreateConversation(userId int, otherId int) (Conversation, error) {
+  var conversationId int
+  err := db.update("CreateConversation", func(dbStructure *DBStructure) error {
+    if userId == otherId {
+      return fmt.Errorf("Users can't start a conversation with themselves")
+    }
+    if _, ok := dbStructure.Users[otherId]; !ok {
+      return fmt.Errorf("No user found with id: %v", otherId)
+    }
+
+    key := pairKey(userId, otherId)
+    if id, ok := dbStructure.indexes.conversationsByPair[key]; ok {
+      conversationId = id
+      return nil
+    }
+
+    conversationId = dbStructure.nextId("conversations")
+    conversationsTable.put(dbStructure, conversationId, Conversation{
+      Id: conversationId,
+      ParticipantIds: key[:],
+      CreatedAt: time.Now().UTC(),
+    })
+    return nil
+  })
+  if err != nil {
+    return Conversation{}, err
+  }
+  return db.GetConversation(conversationId, userId)
+}
+
+// GetConversation returns a conversation to one of its participants
+func (db *DB) GetConversation(conversationId int, userId int) (Conversation, error) {
+  var conversation Conversation
+  err := db.View(func(dbStructure *DBStructure) error {
+    found, err := dbStructure.conversationFor(conversationId, userId)
+    if err != nil {
+      return err
+    }
+    conversation = dbStructure.expandConversation(found, userId)
+    return nil
+  })
+  if err != nil {
+    return Conversation{}, err
+  }
+  return conversation, nil
+}
+
+// conversationCursor is the decoded form of a conversation cursor: the
+// activity of the conversation at the edge of a page and which side of
+// it the next page is on. Paging by activity rather than position keeps
+// pages from skipping or repeating conversations when a new message
+// moves one to the top.
+type conversationCursor struct {
+  LastMessageId int `json:"last_message_id"`
+  Id int `json:"id"`
+  Before bool `json:"before,omitempty"`
+}
+
+func encodeConversationCursor(a activity, before bool) string {
+  data, _ := json.Marshal(conversationCursor{LastMessageId: a.lastMessageId, Id: a.conversationId, Before: before})
+  return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeConversationCursor(value string) (conversationCursor, error) {
+  data, err := base64.RawURLEncoding.DecodeString(value)
+  if err != nil {
+    return conversationCursor{}, ErrInvalidCursor
+  }
+  var cursor conversationCursor
+  if err := json.Unmarshal(data, &cursor); err != nil {
+    return conversationCursor{}, ErrInvalidCursor
+  }
+  return cursor, nil
+}
+
+// ListConversations returns a page of a user's conversations, the most
+// recently active first. limit of zero returns them all. Pages are read
+// from the user's activity index, so the cost depends on the page size
+// rather than on how many conversations the user has.
+func (db *DB) ListConversations(userId int, limit int, cursor string) (ConversationPage, error) {
+  var decoded *conversationCursor
+  if cursor != "" {
+    found, err := decodeConversationCursor(cursor)
+    if err != nil {
+      return ConversationPage{}, err
+    }
+    decoded = &found
+  }
+
+  page := ConversationPage{Conversations: []Conversation{}}
+  err := db.View(func(dbStructure *DBStructure) error {
+    // The list is least recently active first, so it's read backwards
+    list := dbStructure.indexes.conversationsByActivity[userId]
+    at := func(i int) activity { return list[len(list)-1-i] }
+
+    start, end := 0, len(list)
+    if decoded != nil {
+      edge := activity{lastMessageId: decoded.LastMessageId, conversationId: decoded.Id}
+      if decoded.Before {
+        // Conversations more active than the edge
+        end = len(list) - sort.Search(len(list), func(i int) bool { return edge.less(list[i]) })
+      } else {
+        // Conversations less active than the edge
+        start = len(list) - searchActivity(list, edge)
+      }
+    }
+    if limit > 0 && end-start > limit {
+      if decoded != nil && decoded.Before {
+        start = end - limit
+      } else {
+        end = start + limit
+      }
+    }
+
+    for i := start; i < end; i++ {
+      id := at(i).conversationId
+      page.Conversations = append(page.Conversations, dbStructure.expandConversation(dbStructure.Conversations[id], userId))
+    }
+    if start < end && end < len(list) {
+      page.NextCursor = encodeConversationCursor(at(end-1), false)
+    }
+    if start < end && start > 0 {
+      page.PrevCursor = encodeConversationCursor(at(start), true)
+    }
+    return nil
+  })
+  if err != nil {
+    return ConversationPage{}, err
+  }
+  return page, nil
+}
+
+// SendMessage adds a message to a conversation the sender takes part in.
+// Sending a message marks the conversation read up to it for the sender.
+func (db *DB) SendMessage(conversationId int, senderId int, body string) (Message, error) {
+  var message Message
+  err := db.update("SendMessage", func(dbStructure *DBStructure) error {
+    if _, err := dbStructure.conversationFor(conversationId, senderId); err != nil {
+      return err
+    }
+
+    now := time.Now().UTC()
+    message = Message{
+      Id: dbStructure.nextId("messages"),
+      ConversationId: conversationId,
+      SenderId: senderId,
+      Body: body,
+      CreatedAt: now,
+    }
+    messagesTable.put(dbStructure, message.Id, message)
+    dbStructure.markRead(conversationId, senderId, message.Id, now)
+    return nil
+  })
+  if err != nil {
+    return Message{}, err
+  }
+  return message, nil
+}
+
+// ListMessages returns a page of a conversation's messages to one of
+// its participants, newest first
+func (db *DB) ListMessages(query MessageQuery) (MessagePage, error) {
+  var cursor *chirpCursor
+  if query.Cursor != "" {
+    decoded, err := decodeCursor(query.Cursor)
+    if err != nil {
+      return MessagePage{}, err
+    }
+    cursor = &decoded
+  }
+
+  page := MessagePage{Messages: []Message{}}
+  err := db.View(func(dbStructure *DBStructure) error {
+    if _, err := dbStructure.conversationFor(query.ConversationId, query.UserId); err != nil {
+      return err
+    }
+
+    ordered := orderedIds{ids: dbStructure.indexes.messagesByConversation[query.ConversationId], descending: true}
+    start, end := 0, ordered.len()
+    switch {
+    case cursor == nil:
+    case cursor.Before:
+      end = ordered.countBefore(cursor.Id)
+    default:
+      start = ordered.countThrough(cursor.Id)
+    }
+    if query.Limit > 0 && end-start > query.Limit {
+      if cursor != nil && cursor.Before {
+        start = end - query.Limit
+      } else {
+        end = start + query.Limit
+      }
+    }
+
+    for i := start; i < end; i++ {
+      page.Messages = append(page.Messages, dbStructure.Messages[ordered.at(i)])
+    }
+    if start < end && end < ordered.len() {
+      page.NextCursor = encodeCursor(chirpCursor{Id: ordered.at(end - 1)})
+    }
+    if start < end && start > 0 {
+      page.PrevCursor = encodeCursor(chirpCursor{Id: ordered.at(start), Before: true})
+    }
+    return nil
+  })
+  if err != nil {
+    return MessagePage{}, err
+  }
+  return page, nil
+}
+
+// MarkConversationRead moves userId's read receipt up to messageId, or
+// to the newest message if messageId is zero. Receipts never move back.
+func (db *DB) MarkConversationRead(conversationId int, userId int, messageId int) (Conversation, error) {
+  err := db.update("MarkConversationRead", func(dbStructure *DBStructure) error {
+    if _, err := dbStructure.conversationFor(conversationId, userId); err != nil {
+      return err
+    }
+
+    messageIds := dbStructure.indexes.messagesByConversation[conversationId]
+    if messageId == 0 {
+      if len(messageIds) == 0 {
+        return nil
+      }
+      messageId = messageIds[len(messageIds)-1]
+    }
+    if message, ok := dbStructure.Messages[messageId]; !ok || message.ConversationId != conversationId {
+      return fmt.Errorf("No message found with id: %v", messageId)
+    }
+
+    dbStructure.markRead(conversationId, userId, messageId, time.Now().UTC())
+    return nil
+  })
+  if err != nil {
+    return Conversation{}, err
+  }
+  return db.GetConversation(conversationId, userId)
+}
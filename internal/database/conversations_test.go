@@ -0,0 +1,88 @@
+package database
+
+import (
+  "testing"
+)
+
+// conversationIds lists the ids of a page's conversations
+func conversationIds(page ConversationPage) []int {
+  ids := []int{}
+  for _, conversation := range page.Conversations {
+    ids = append(ids, conversation.Id)
+  }
+  return ids
+}
+
+func TestListConversationsKeepsPlaceWhenOneMovesUp(t *testing.T) {
+  db := seedDB(t, 0, Options{})
+
+  // User 1 talks to users 2 to 6, so the conversation with user 6 is
+  // the most recently active
+  ids := []int{}
+  for otherId := 2; otherId <= 6; otherId++ {
+    conversation, err := db.CreateConversation(1, otherId)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if _, err := db.SendMessage(conversation.Id, otherId, "Hello"); err != nil {
+      t.Fatal(err)
+    }
+    ids = append([]int{conversation.Id}, ids...)
+  }
+
+  first, err := db.ListConversations(1, 2, "")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got := conversationIds(first); len(got) != 2 || got[0] != ids[0] || got[1] != ids[1] {
+    t.Fatalf("First page is %v, expected %v", got, ids[:2])
+  }
+
+  // A message in the last conversation moves it to the top between pages
+  if _, err := db.SendMessage(ids[4], 1, "Still there?"); err != nil {
+    t.Fatal(err)
+  }
+
+  seen := map[int]bool{ids[0]: true, ids[1]: true}
+  cursor := first.NextCursor
+  for cursor != "" {
+    page, err := db.ListConversations(1, 2, cursor)
+    if err != nil {
+      t.Fatal(err)
+    }
+    for _, id := range conversationIds(page) {
+      if seen[id] {
+        t.Errorf("Conversation %v was listed twice", id)
+      }
+      seen[id] = true
+    }
+    cursor = page.NextCursor
+  }
+  for _, id := range ids[2:4] {
+    if !seen[id] {
+      t.Errorf("Conversation %v was skipped", id)
+    }
+  }
+
+  // Paging back from the second page returns the two just above it, and
+  // the moved conversation is on top when listing from the start
+  second, _ := db.ListConversations(1, 2, first.NextCursor)
+  previous, err := db.ListConversations(1, 2, second.PrevCursor)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got := conversationIds(previous); len(got) != 2 || got[0] != ids[0] || got[1] != ids[1] {
+    t.Errorf("Previous page is %v, expected %v", got, ids[:2])
+  }
+  top, _ := db.ListConversations(1, 0, "")
+  if got := conversationIds(top); len(got) != 5 || got[0] != ids[4] {
+    t.Errorf("Conversations are %v, expected %v first", got, ids[4])
+  }
+}
+
+func TestListConversationsBadCursor(t *testing.T) {
+  db := seedDB(t, 0, Options{})
+  if _, err := db.ListConversations(1, 2, "not-a-cursor"); err != ErrInvalidCursor {
+    t.Errorf("Got %v, expected ErrInvalidCursor", err)
+  }
+}
@@ -2,15 +2,31 @@ package database
 
 import (
   "fmt"
-  "os"
   "sync"
+  "time"
+  "strconv"
   "encoding/json"
-  "sort"
 )
 
 type DB struct {
 	path string
 	mux  *sync.RWMutex
+  storage storage
+  options Options
+  // cache is the source of truth. Updates change it in place under the
+  // write lock, so readers must not hold on to it after unlocking.
+  cache *DBStructure
+  // pending counts updates not yet written to storage
+  pending int
+  // unflushed holds the latest change to each record that hasn't been
+  // written yet, for storage that can write single records
+  unflushed map[string]journalChange
+  flushMux sync.Mutex
+  flushNow chan struct{}
+  done chan struct{}
+  flusher sync.WaitGroup
+  migrations MigrationReport
+  search *searchIndex
 }
 
 type RefreshToken struct {
@@ -26,250 +42,525 @@ type User struct {
   Token string `json:"-"`
   RefreshToken RefreshToken `json:"refresh_token"`
   IsChirpyRed bool `json:"is_chirpy_red"`
+  CreatedAt time.Time `json:"created_at"`
+  UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Chirp struct {
   Id int `json:"id"`
   Body string `json:"body"`
   AuthorId int `json:"author_id"`
+  // InReplyTo is the id of the chirp this one replies to, if any
+  InReplyTo int `json:"in_reply_to,omitempty"`
+  // Mentions, Hashtags and Links are found in the body when it's written
+  Mentions []Mention `json:"mentions"`
+  Hashtags []Hashtag `json:"hashtags"`
+  Links []Link `json:"links"`
+  Attachments []Attachment `json:"attachments"`
+  // RechirpOf is the id of the chirp this one reposts, if any.
+  // Rechirps have no body of their own.
+  RechirpOf int `json:"rechirp_of,omitempty"`
+  // ReplyCount, LikeCount, RechirpCount and Rechirped are filled in
+  // when the chirp is read and aren't stored
+  ReplyCount int `json:"reply_count"`
+  LikeCount int `json:"like_count"`
+  RechirpCount int `json:"rechirp_count"`
+  Rechirped *Chirp `json:"rechirped,omitempty"`
+  CreatedAt time.Time `json:"created_at"`
+  UpdatedAt time.Time `json:"updated_at"`
+  // DeletedAt and DeletedBy mark a chirp as deleted without removing
+  // it, so the deletion can be audited and undone until it's purged
+  DeletedAt *time.Time `json:"deleted_at,omitempty"`
+  DeletedBy int `json:"deleted_by,omitempty"`
+  // HiddenAt and HiddenBy mark a chirp an admin has hidden from
+  // everyone after it was reported
+  HiddenAt *time.Time `json:"hidden_at,omitempty"`
+  HiddenBy int `json:"hidden_by,omitempty"`
 }
 
 type DBStructure struct {
 	Chirps map[int]Chirp `json:"chirps"`
 	Users map[int]User `json:"users"`
+  Revisions map[int]ChirpRevision `json:"revisions"`
+  Likes map[int]Like `json:"likes"`
+  Follows map[int]Follow `json:"follows"`
+  Conversations map[int]Conversation `json:"conversations"`
+  Messages map[int]Message `json:"messages"`
+  ReadReceipts map[int]ReadReceipt `json:"read_receipts"`
+  ModerationResults map[int]ModerationResult `json:"moderation_results"`
+  Reports map[int]Report `json:"reports"`
+  ModerationLog map[int]ModerationLogEntry `json:"moderation_log"`
+  Notifications map[int]Notification `json:"notifications"`
+  LinkPreviews map[int]LinkPreview `json:"link_previews"`
+  SchemaVersion int `json:"schema_version"`
+  // Sequences holds the last id handed out for each collection
+  Sequences map[string]int `json:"sequences"`
+  JournalSeq int `json:"journal_seq"`
+  indexes *indexes
+  // tx records what the running update has changed
+  tx *tx
 }
 
 // NewDB creates a new database connection
 // and creates the database file if it doesn't exist
 func NewDB(path string) (*DB, error) {
+  return newDB(path, &jsonStorage{path: path}, Options{})
+}
+
+// newDB wraps a storage backend, makes sure it is ready for use
+// and loads it into memory
+func newDB(path string, storage storage, options Options) (*DB, error) {
   mux := &sync.RWMutex{}
 
   db := &DB{
     path: path,
     mux: mux,
+    storage: storage,
+    options: options,
+    unflushed: map[string]journalChange{},
+    flushNow: make(chan struct{}, 1),
+    done: make(chan struct{}),
   }
 
-  err := db.ensureDB()
+  var dbStructure DBStructure
+  var err error
+  if options.MigrationDryRun {
+    dbStructure, err = db.loadDBReadOnly()
+  } else {
+    err = db.ensureDB()
+    if err == nil {
+      dbStructure, err = db.loadDB()
+    }
+  }
   if err != nil {
     return nil, err
   }
+  dbStructure, err = db.migrate(dbStructure.clone())
+  if err != nil {
+    return nil, err
+  }
+  if err := dbStructure.buildIndexes(); err != nil {
+    return nil, err
+  }
+  db.cache = &dbStructure
+  db.search = buildSearchIndex(dbStructure.Chirps)
+
+  // Journaled storage is rewritten in the background even when every
+  // change is written through to the journal
+  if _, journaled := storage.(journaledStorage); journaled || options.FlushInterval > 0 {
+    db.flusher.Add(1)
+    go db.flushLoop()
+  }
 
   return db, nil
 }
 
+// View runs fn against a consistent snapshot of the database while
+// holding the read lock. fn must not modify the snapshot.
+func (db *DB) View(fn func(*DBStructure) error) error {
+  db.mux.RLock()
+  defer db.mux.RUnlock()
+
+  dbStructure := *db.cache
+  return fn(&dbStructure)
+}
+
+// Update runs fn against the database while holding the write lock
+// and commits its changes if fn returns nil, so the whole of fn commits
+// or none of it does. fn must write records through DBStructure methods
+// like PutUser rather than assigning to the maps, so the changes are
+// tracked and saved. Records are stored by value, so fn should replace
+// slices inside a record rather than change their elements.
+func (db *DB) Update(fn func(*DBStructure) error) error {
+  return db.update("Update", fn)
+}
+
+// update is Update with the op name recorded in the journal. fn changes
+// the cache in place and every record it writes is tracked, so the
+// commit only costs as much as fn changed. Indexes read inside fn still
+// describe the database as it was before fn started.
+func (db *DB) update(op string, fn func(*DBStructure) error) error {
+  db.mux.Lock()
+  defer db.mux.Unlock()
+
+  dbStructure := db.cache
+  tx := newTx()
+  dbStructure.tx = tx
+  err := fn(dbStructure)
+  dbStructure.tx = nil
+  if err != nil {
+    tx.rollback(dbStructure)
+    return err
+  }
+  if tx.empty() {
+    return nil
+  }
+
+  if err := db.commit(op, tx); err != nil {
+    tx.rollback(dbStructure)
+    // The indexes may already have taken some of the changes
+    dbStructure.buildIndexes()
+    return err
+  }
+  return nil
+}
+
+// commit indexes and saves the changes tracked by tx, which have
+// already been made to the cache
+func (db *DB) commit(op string, tx *tx) error {
+  if err := db.cache.indexes.update(db.cache, tx); err != nil {
+    return err
+  }
+  changes, err := tx.journalChanges(db.cache)
+  if err != nil {
+    return err
+  }
+  if err := db.writeDB(op, changes); err != nil {
+    return err
+  }
+  db.search.apply(tx)
+  return nil
+}
+
+// replace swaps the whole database for next, for operations like Reset
+// and Restore that change everything at once. Unlike update it costs as
+// much as the database is big.
+func (db *DB) replace(op string, next DBStructure) error {
+  db.mux.Lock()
+  defer db.mux.Unlock()
+
+  next = next.clone()
+  // Keep counting journal entries from where the live database is,
+  // so replay doesn't skip anything written after the replace
+  next.JournalSeq = db.cache.JournalSeq
+  if err := next.buildIndexes(); err != nil {
+    return err
+  }
+  changes, err := diffStructures(*db.cache, next)
+  if err != nil {
+    return err
+  }
+
+  previous := db.cache
+  db.cache = &next
+  if err := db.writeDB(op, changes); err != nil {
+    db.cache = previous
+    return err
+  }
+  db.search = buildSearchIndex(next.Chirps)
+  return nil
+}
+
 func (db *DB) FindUserById(id int) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-  for _, dbUser := range dbStructure.Users {
-    if dbUser.Id == id {
-      return dbUser, nil
+  var foundUser User
+  err := db.View(func(dbStructure *DBStructure) error {
+    dbUser, ok := dbStructure.Users[id]
+    if !ok {
+      return fmt.Errorf("No user found with id: %v", id)
     }
+    foundUser = dbUser
+    return nil
+  })
+  if err != nil {
+    return User{}, err
   }
-  return User{}, fmt.Errorf("No user found with id: %v", id)
+  return foundUser, nil
 }
 
+// FindUserByEmail looks a user up through the email index
+func (db *DB) FindUserByEmail(email string) (User, error) {
+  var foundUser User
+  err := db.View(func(dbStructure *DBStructure) error {
+    foundUser = dbStructure.findUserByEmail(email)
+    if (User{}) == foundUser {
+      return fmt.Errorf("No user found with email: %v", email)
+    }
+    return nil
+  })
+  if err != nil {
+    return User{}, err
+  }
+  return foundUser, nil
+}
 
+// FindUser returns the user with the same email as user,
+// or an empty User if there isn't one
 func (db *DB) FindUser(user User) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-  for _, dbUser := range dbStructure.Users {
-    if dbUser.Email == user.Email {
-      return dbUser, nil
-    }
+  var foundUser User
+  err := db.View(func(dbStructure *DBStructure) error {
+    foundUser = dbStructure.findUserByEmail(user.Email)
+    return nil
+  })
+  if err != nil {
+    return User{}, err
   }
-  return User{}, err
+  return foundUser, nil
 }
 
 func (db *DB) UpdateUser(user User) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-	dbStructure.Users[user.Id] = user
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return User{}, err
-	}
-
-	return user, nil
+  user.UpdatedAt = time.Now().UTC()
+  err := db.update("UpdateUser", func(dbStructure *DBStructure) error {
+    usersTable.put(dbStructure, user.Id, user)
+    return nil
+  })
+  if err != nil {
+    return User{}, err
+  }
+  return user, nil
 }
 
 func (db *DB) DeleteRefreshToken(refreshToken string) (error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
-
-  for i, user := range dbStructure.Users {
-    if user.RefreshToken.RefreshToken == refreshToken {
+  return db.update("DeleteRefreshToken", func(dbStructure *DBStructure) error {
+    user := dbStructure.findUserByRefreshToken(refreshToken)
+    if (User{}) != user {
       user.RefreshToken = RefreshToken{}
-      dbStructure.Users[i] = user
+      usersTable.put(dbStructure, user.Id, user)
     }
-  }
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-
-	return nil
+    return nil
+  })
 }
 
 func (db *DB) FindRefreshToken(refreshToken string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-
-  for _, user := range dbStructure.Users {
-    if user.RefreshToken.RefreshToken == refreshToken {
-      return user, nil
-    }
+  var foundUser User
+  err := db.View(func(dbStructure *DBStructure) error {
+    foundUser = dbStructure.findUserByRefreshToken(refreshToken)
+    return nil
+  })
+  if err != nil {
+    return User{}, err
   }
-
-	return User{}, nil
+  return foundUser, nil
 }
 
 func (db *DB) CreateRefreshToken(user User, refreshToken RefreshToken) (RefreshToken, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return RefreshToken{}, err
-	}
-
-  foundUser := dbStructure.Users[user.Id]
-  foundUser.RefreshToken = refreshToken
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return RefreshToken{}, err
-	}
-
-	return refreshToken, nil
+  err := db.update("CreateRefreshToken", func(dbStructure *DBStructure) error {
+    foundUser, ok := dbStructure.Users[user.Id]
+    if !ok {
+      return fmt.Errorf("No user found with id: %v", user.Id)
+    }
+    foundUser.RefreshToken = refreshToken
+    usersTable.put(dbStructure, user.Id, foundUser)
+    return nil
+  })
+  if err != nil {
+    return RefreshToken{}, err
+  }
+  return refreshToken, nil
 }
 
 func (db *DB) CreateUser(user User) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
+  err := db.update("CreateUser", func(dbStructure *DBStructure) error {
+    if (User{}) != dbStructure.findUserByEmail(user.Email) {
+      return fmt.Errorf("User already exists")
+    }
 
-  existingUser, err := db.FindUser(user)
+    id := dbStructure.nextId("users")
+    user.Id = id
+    user.CreatedAt = time.Now().UTC()
+    user.UpdatedAt = user.CreatedAt
+    usersTable.put(dbStructure, id, user)
+    return nil
+  })
   if err != nil {
     return User{}, err
   }
-  if (User{}) != existingUser {
-    return User{}, fmt.Errorf("User already exists")
-  }
-
-	id := len(dbStructure.Users) + 1
-  user.Id = id
-	dbStructure.Users[id] = user
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return User{}, err
-	}
-
-	return user, nil
+  return user, nil
 }
 
-func (db *DB) DeleteChirp(id int) (error) {
-  dbStructure, err := db.loadDB()
-  if err != nil {
-    return err
-  }
-
-  chirps := []Chirp{}
-  for _, chirp := range dbStructure.Chirps {
-    if chirp.Id != id {
-      chirps = append(chirps, chirp)
+// DeleteChirp tombstones a chirp, recording who deleted it and when
+func (db *DB) DeleteChirp(id int, deletedBy int) (error) {
+  return db.update("DeleteChirp", func(dbStructure *DBStructure) error {
+    chirp, ok := dbStructure.Chirps[id]
+    if !ok || chirp.IsDeleted() {
+      return fmt.Errorf("No chirp found with id: %v", id)
     }
-  }
 
-  sort.Slice(chirps, func(i, j int) bool { return chirps[i].Id < chirps[j].Id})
-  return nil
+    deletedAt := time.Now().UTC()
+    chirp.DeletedAt = &deletedAt
+    chirp.DeletedBy = deletedBy
+    chirpsTable.put(dbStructure, id, chirp)
+    return nil
+  })
 }
 
 // CreateChirp creates a new chirp and saves it to disk
 func (db *DB) CreateChirp(body string, author_id int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
-	}
-
-	id := len(dbStructure.Chirps) + 1
-	chirp := Chirp{
-		Id: id,
-		Body: body,
-    AuthorId: author_id,
-	}
-	dbStructure.Chirps[id] = chirp
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return Chirp{}, err
-	}
-
-	return chirp, nil
+  return db.createChirp("CreateChirp", body, author_id, 0, nil)
 }
 
-// GetChirps returns all chirps in the database
-func (db *DB) GetChirps() ([]Chirp, error) {
-  dbStructure, err := db.loadDB()
+// CreateChirpWithAttachments creates a chirp with media attached,
+// replying to inReplyTo unless it's zero. The files have to be stored
+// already; only their metadata is kept here.
+func (db *DB) CreateChirpWithAttachments(body string, authorId int, inReplyTo int, attachments []Attachment) (Chirp, error) {
+  return db.createChirp("CreateChirpWithAttachments", body, authorId, inReplyTo, attachments)
+}
+
+// createChirp creates a chirp, replying to inReplyTo unless it's zero
+func (db *DB) createChirp(op string, body string, authorId int, inReplyTo int, attachments []Attachment) (Chirp, error) {
+  var chirp Chirp
+  err := db.update(op, func(dbStructure *DBStructure) error {
+    if inReplyTo != 0 {
+      // Replies to a rechirp go to the chirp it reposts
+      parent, err := dbStructure.original(inReplyTo)
+      if err != nil {
+        return err
+      }
+      inReplyTo = parent.Id
+    }
+
+    id := dbStructure.nextId("chirps")
+    if db.options.TimeOrderedChirpIds {
+      id = dbStructure.nextTimeOrderedId("chirps", time.Now())
+    }
+    now := time.Now().UTC()
+    mentions, hashtags, links := dbStructure.extractChirpEntities(body)
+    if attachments == nil {
+      attachments = []Attachment{}
+    }
+    chirp = Chirp{
+      Id: id,
+      Body: body,
+      AuthorId: authorId,
+      InReplyTo: inReplyTo,
+      Mentions: mentions,
+      Hashtags: hashtags,
+      Links: links,
+      Attachments: attachments,
+      CreatedAt: now,
+      UpdatedAt: now,
+    }
+    chirpsTable.put(dbStructure, id, chirp)
+    return nil
+  })
   if err != nil {
-    return []Chirp{}, err
+    return Chirp{}, err
   }
+  return chirp, nil
+}
 
+// ListChirpsByAuthor returns an author's chirps in ascending id order
+func (db *DB) ListChirpsByAuthor(authorId int) ([]Chirp, error) {
   chirps := []Chirp{}
-  for _, chirp := range dbStructure.Chirps {
-    chirps = append(chirps, chirp)
+  err := db.View(func(dbStructure *DBStructure) error {
+    chirps = dbStructure.chirpsByAuthor(authorId)
+    return nil
+  })
+  if err != nil {
+    return []Chirp{}, err
   }
-
   return chirps, nil
 }
 
-// ensureDB creates a new database file if it doesn't exist
-func (db *DB) ensureDB() error {
-  _, err := os.ReadFile(db.path)
+// GetChirps returns all chirps in the database that are listed
+func (db *DB) GetChirps() ([]Chirp, error) {
+  chirps := []Chirp{}
+  err := db.View(func(dbStructure *DBStructure) error {
+    for _, chirp := range dbStructure.Chirps {
+      if dbStructure.isListed(chirp) {
+        chirps = append(chirps, dbStructure.expand(chirp))
+      }
+    }
+    return nil
+  })
   if err != nil {
-    os.WriteFile(db.path, []byte(`{"chirps": {}, "users": {}}`), 0666)
+    return []Chirp{}, err
   }
-  return nil
+  return chirps, nil
 }
 
-// loadDB reads the database file into memory
+// ensureDB creates a new database if it doesn't exist
+func (db *DB) ensureDB() error {
+  return db.storage.ensure()
+}
+
+// loadDB reads the database from its storage
 func (db *DB) loadDB() (DBStructure, error) {
-  data, err := os.ReadFile(db.path)
+  return db.storage.load()
+}
+
+// loadDBReadOnly reads the database along with any journal entries
+// it is missing without changing storage at all: nothing is created,
+// replayed onto disk or checkpointed
+func (db *DB) loadDBReadOnly() (DBStructure, error) {
+  exists, err := db.storage.exists()
   if err != nil {
     return DBStructure{}, err
   }
+  if !exists {
+    return DBStructure{}, fmt.Errorf("No database found at %v", db.path)
+  }
 
-  var dbStructure DBStructure
-  if err := json.Unmarshal(data, &dbStructure); err != nil {
+  dbStructure, err := db.storage.load()
+  if err != nil {
     return DBStructure{}, err
   }
-
+  if journal, ok := db.storage.(journaledStorage); ok {
+    dbStructure, _, err = journal.replay(dbStructure)
+    if err != nil {
+      return DBStructure{}, err
+    }
+  }
   return dbStructure, nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
-  data, err := json.Marshal(dbStructure)
-  if err != nil {
-    return err
+// writeDB saves changes the caller has already made to the cache. They
+// are journaled straight away where the backend keeps a journal, and
+// the journaled file is rewritten by the flusher once enough entries
+// pile up. Other storage gets just the changed records, now or from the
+// flusher depending on the options. op names the mutation in the
+// journal. The caller holds the write lock.
+func (db *DB) writeDB(op string, changes []journalChange) error {
+  seq := db.cache.JournalSeq + 1
+  journal, journaled := db.storage.(journaledStorage)
+  if journaled {
+    if err := journal.journal(op, seq, changes); err != nil {
+      return err
+    }
+  }
+  changes = append(changes, journalChange{Table: "journal_seq", Data: json.RawMessage(strconv.Itoa(seq))})
+
+  incremental, isIncremental := db.storage.(incrementalStorage)
+  if db.options.FlushInterval <= 0 && !journaled {
+    if isIncremental {
+      if err := incremental.writeChanges(changes); err != nil {
+        return err
+      }
+    } else if err := db.storage.write(*db.cache); err != nil {
+      return err
+    }
+    db.cache.JournalSeq = seq
+    return nil
   }
 
-  if err := os.WriteFile(db.path, []byte(data), 0666); err != nil {
-    return err
+  db.cache.JournalSeq = seq
+  if isIncremental {
+    for _, change := range changes {
+      db.unflushed[change.Table+"/"+change.Id] = change
+    }
+  }
+  db.pending++
+
+  limit := db.options.MaxPendingWrites
+  if db.options.FlushInterval <= 0 {
+    limit = checkpointEntries
+  }
+  if limit > 0 && db.pending >= limit {
+    select {
+    case db.flushNow <- struct{}{}:
+    default:
+    }
   }
   return nil
 }
 
+// Close flushes pending changes and releases any resources
+// held by the storage backend
+func (db *DB) Close() error {
+  close(db.done)
+  db.flusher.Wait()
+
+  if err := db.Flush(); err != nil {
+    return err
+  }
+  return db.storage.close()
+}
@@ -0,0 +1,258 @@
+package database
+
+import (
+  "fmt"
+  "time"
+  "errors"
+  "testing"
+  "database/sql"
+  "path/filepath"
+)
+
+// benchmarkSizes are the numbers of chirps the benchmarks run against
+var benchmarkSizes = []int{1000, 10000, 100000}
+
+// seedDB writes a JSON database holding chirps chirps spread over 100
+// users, then opens it
+func seedDB(tb testing.TB, chirps int, options Options) *DB {
+  return seedBackend(tb, BackendJSON, chirps, options)
+}
+
+// seedBackend is seedDB for any backend that keeps a file
+func seedBackend(tb testing.TB, backend string, chirps int, options Options) *DB {
+  tb.Helper()
+  dbStructure := DBStructure{SchemaVersion: SchemaVersion}.clone()
+  now := time.Now().UTC()
+  for id := 1; id <= 100; id++ {
+    dbStructure.Users[id] = User{
+      Id: id,
+      Email: fmt.Sprintf("user%d@example.com", id),
+      CreatedAt: now,
+      UpdatedAt: now,
+    }
+  }
+  if err := dbStructure.buildIndexes(); err != nil {
+    tb.Fatal(err)
+  }
+  for id := 1; id <= chirps; id++ {
+    body := fmt.Sprintf("Chirp number %d for @user%d about #topic%d", id, id%100+1, id%50)
+    mentions, hashtags, links := dbStructure.extractChirpEntities(body)
+    dbStructure.Chirps[id] = Chirp{
+      Id: id,
+      Body: body,
+      AuthorId: id%100 + 1,
+      Mentions: mentions,
+      Hashtags: hashtags,
+      Links: links,
+      Attachments: []Attachment{},
+      CreatedAt: now,
+      UpdatedAt: now,
+    }
+  }
+  dbStructure.initSequences()
+
+  path := filepath.Join(tb.TempDir(), "database."+backend)
+  var storage storage = &jsonStorage{path: path}
+  if backend == BackendSQLite {
+    conn, err := sql.Open("sqlite", path)
+    if err != nil {
+      tb.Fatal(err)
+    }
+    conn.SetMaxOpenConns(1)
+    storage = &sqliteStorage{path: path, conn: conn}
+    if err := storage.ensure(); err != nil {
+      tb.Fatal(err)
+    }
+  }
+  if err := storage.write(dbStructure); err != nil {
+    tb.Fatal(err)
+  }
+  db, err := newDB(path, storage, options)
+  if err != nil {
+    tb.Fatal(err)
+  }
+  tb.Cleanup(func() { db.Close() })
+  return db
+}
+
+// BenchmarkFindChirpById compares reading a chirp from memory with
+// loading the whole database file for every read, as the DB used to
+func BenchmarkFindChirpById(b *testing.B) {
+  for _, size := range benchmarkSizes {
+    db := seedDB(b, size, Options{})
+
+    b.Run(fmt.Sprintf("cached/%d", size), func(b *testing.B) {
+      for i := 0; i < b.N; i++ {
+        if _, err := db.FindChirpById(i%size + 1); err != nil {
+          b.Fatal(err)
+        }
+      }
+    })
+    b.Run(fmt.Sprintf("load/%d", size), func(b *testing.B) {
+      for i := 0; i < b.N; i++ {
+        dbStructure, err := db.storage.load()
+        if err != nil {
+          b.Fatal(err)
+        }
+        if _, ok := dbStructure.Chirps[i%size+1]; !ok {
+          b.Fatalf("Chirp %v is missing", i%size+1)
+        }
+      }
+    })
+  }
+}
+
+// BenchmarkCreateChirp writes chirps to databases of different sizes.
+// The cost of a write should depend on the chirp, not on the size.
+func BenchmarkCreateChirp(b *testing.B) {
+  modes := []struct {
+    name string
+    backend string
+    options Options
+  }{
+    {"json/write-through", BackendJSON, Options{}},
+    {"json/write-behind", BackendJSON, Options{FlushInterval: time.Second}},
+    {"sqlite/write-through", BackendSQLite, Options{}},
+    {"sqlite/write-behind", BackendSQLite, Options{FlushInterval: time.Second}},
+  }
+  for _, mode := range modes {
+    for _, size := range benchmarkSizes {
+      b.Run(fmt.Sprintf("%s/%d", mode.name, size), func(b *testing.B) {
+        db := seedBackend(b, mode.backend, size, mode.options)
+        b.ResetTimer()
+        for i := 0; i < b.N; i++ {
+          if _, err := db.CreateChirp("Benchmarking @user1 #topic1", i%100+1); err != nil {
+            b.Fatal(err)
+          }
+        }
+      })
+    }
+  }
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+  db := seedDB(t, 10, Options{})
+
+  failed := errors.New("failed")
+  err := db.Update(func(dbStructure *DBStructure) error {
+    chirpsTable.put(dbStructure, 1, Chirp{Id: 1, Body: "Changed", AuthorId: 1})
+    chirpsTable.remove(dbStructure, 2)
+    dbStructure.nextId("chirps")
+    user := dbStructure.Users[1]
+    user.Email = "changed@example.com"
+    dbStructure.PutUser(user)
+    return failed
+  })
+  if err != failed {
+    t.Fatalf("Got %v, expected the error from fn", err)
+  }
+
+  db.View(func(dbStructure *DBStructure) error {
+    if dbStructure.Chirps[1].Body != "Chirp number 1 for @user2 about #topic1" {
+      t.Errorf("Chirp 1 wasn't put back: %+v", dbStructure.Chirps[1])
+    }
+    if _, ok := dbStructure.Chirps[2]; !ok {
+      t.Error("Chirp 2 wasn't put back")
+    }
+    if dbStructure.Sequences["chirps"] != 10 {
+      t.Errorf("Chirp sequence is %v, expected 10", dbStructure.Sequences["chirps"])
+    }
+    if dbStructure.findUserByEmail("user1@example.com").Id != 1 {
+      t.Error("User 1's email wasn't put back")
+    }
+    return nil
+  })
+}
+
+func TestJournalReplay(t *testing.T) {
+  db := seedDB(t, 10, Options{})
+  chirp, err := db.CreateChirp("Journaled", 1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := db.DeleteChirp(3, 1); err != nil {
+    t.Fatal(err)
+  }
+  rechirp, _, err := db.Rechirp(4, 1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, _, err := db.Unrechirp(4, 1); err != nil {
+    t.Fatal(err)
+  }
+
+  // Open a second copy before the first is closed, as if it had crashed
+  // before the file was rewritten
+  reopened, err := NewDB(db.path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer reopened.Close()
+
+  found, err := reopened.FindChirpById(chirp.Id)
+  if err != nil || found.Body != "Journaled" {
+    t.Errorf("Got %+v, %v, expected the journaled chirp", found, err)
+  }
+  if deleted, err := reopened.FindChirpById(3); err != nil || !deleted.IsDeleted() {
+    t.Errorf("Got %+v, %v, expected chirp 3 to stay deleted", deleted, err)
+  }
+  if _, err := reopened.FindChirpById(rechirp.Id); err == nil {
+    t.Errorf("Rechirp %v came back after being removed", rechirp.Id)
+  }
+  next, err := reopened.CreateChirp("After replay", 1)
+  if err != nil || next.Id != rechirp.Id+1 {
+    t.Errorf("Got %+v, %v, expected id %v", next, err, rechirp.Id+1)
+  }
+}
+
+func TestJournalCheckpointsUnderLoad(t *testing.T) {
+  db := seedDB(t, 10, Options{})
+
+  // Write without pausing, so there are always changes the flusher
+  // hasn't written yet when it finishes
+  writes := 3*checkpointEntries + 1
+  for i := 0; i < writes; i++ {
+    if _, err := db.CreateChirp("Keep going", i%100+1); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  entries, err := readJournal(JournalPath(db.path))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(entries) >= 2*checkpointEntries {
+    t.Errorf("Journal holds %v entries after %v writes, expected it to be truncated", len(entries), writes)
+  }
+  if len(entries) > 0 && entries[0].Seq <= checkpointEntries {
+    t.Errorf("Journal still starts at entry %v", entries[0].Seq)
+  }
+
+  // Whatever is left in the journal still replays over the file
+  reopened, err := NewDB(db.path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer reopened.Close()
+  if chirps, _ := reopened.GetChirps(); len(chirps) != 10+writes {
+    t.Errorf("Got %v chirps after replay, expected %v", len(chirps), 10+writes)
+  }
+}
+
+func TestReset(t *testing.T) {
+  db := seedDB(t, 10, Options{})
+  if err := db.Reset(); err != nil {
+    t.Fatal(err)
+  }
+  if chirps, _ := db.GetChirps(); len(chirps) != 0 {
+    t.Errorf("Got %v chirps after a reset", len(chirps))
+  }
+  if page, _ := db.SearchChirps(SearchQuery{Text: "chirp"}); len(page.Results) != 0 {
+    t.Errorf("Search still finds %v chirps after a reset", len(page.Results))
+  }
+
+  chirp, err := db.CreateChirp("Fresh start", 1)
+  if err != nil || chirp.Id != 1 {
+    t.Errorf("Got %+v, %v, expected ids to start again from 1", chirp, err)
+  }
+}
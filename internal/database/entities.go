@@ -0,0 +1,183 @@
+package database
+
+import (
+  "regexp"
+  "strings"
+  "unicode"
+  "net/url"
+  "unicode/utf8"
+)
+
+// Mention is an @handle in a chirp body that names a user. A handle is
+// either a user's whole email or the part before the @ when only one
+// user's email starts with it. Start and End are offsets in runes.
+type Mention struct {
+  UserId int `json:"user_id"`
+  Handle string `json:"handle"`
+  Start int `json:"start"`
+  End int `json:"end"`
+}
+
+// Hashtag is a #tag in a chirp body. Tag is lower case and without
+// the #. Start and End are offsets in runes.
+type Hashtag struct {
+  Tag string `json:"tag"`
+  Start int `json:"start"`
+  End int `json:"end"`
+}
+
+// Link is an http or https URL in a chirp body. Start and End are
+// offsets in runes. Preview is filled in when the chirp is read, once
+// the page has been previewed.
+type Link struct {
+  Url string `json:"url"`
+  Start int `json:"start"`
+  End int `json:"end"`
+  Preview *LinkPreview `json:"preview,omitempty"`
+}
+
+// linkPattern finds URL candidates, which extractLinks then trims
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'\x60]+`)
+
+// extractLinks finds the URLs in body. Punctuation ending a sentence
+// and closing brackets that weren't opened in the URL are left out.
+func extractLinks(body string) []Link {
+  links := []Link{}
+  for _, match := range linkPattern.FindAllStringIndex(body, -1) {
+    text := body[match[0]:match[1]]
+    for text != "" {
+      last := text[len(text)-1]
+      if strings.IndexByte(".,;:!?*", last) >= 0 ||
+        (last == ')' && strings.Count(text, "(") < strings.Count(text, ")")) ||
+        (last == ']' && strings.Count(text, "[") < strings.Count(text, "]")) {
+        text = text[:len(text)-1]
+        continue
+      }
+      break
+    }
+
+    parsed, err := url.Parse(text)
+    if err != nil || parsed.Host == "" {
+      continue
+    }
+    start := utf8.RuneCountInString(body[:match[0]])
+    links = append(links, Link{Url: text, Start: start, End: start + utf8.RuneCountInString(text)})
+  }
+  return links
+}
+
+// NormalizeHashtag returns the form hashtags are stored and looked up in
+func NormalizeHashtag(tag string) string {
+  return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func isHashtagRune(r rune) bool {
+  return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isHandleRune(r rune) bool {
+  return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._%+-@", r)
+}
+
+// resolveHandle finds the user a mention handle names
+func (s *DBStructure) resolveHandle(handle string) (int, bool) {
+  if id, ok := s.indexes.usersByEmail[handle]; ok {
+    return id, true
+  }
+  ids := s.indexes.usersByHandle[strings.ToLower(handle)]
+  if len(ids) == 1 {
+    return ids[0], true
+  }
+  return 0, false
+}
+
+// extractEntities finds the mentions and hashtags in body. Mentions of
+// handles that don't name a user are left out.
+func (s *DBStructure) extractEntities(body string) ([]Mention, []Hashtag) {
+  return s.findEntities(body, nil)
+}
+
+// extractChirpEntities finds the mentions, hashtags and links in body,
+// leaving out @ and # inside links
+func (s *DBStructure) extractChirpEntities(body string) ([]Mention, []Hashtag, []Link) {
+  links := extractLinks(body)
+  mentions, hashtags := s.findEntities(body, links)
+  return mentions, hashtags, links
+}
+
+// findEntities finds the mentions and hashtags in body outside of
+// links, which must be in order
+func (s *DBStructure) findEntities(body string, links []Link) ([]Mention, []Hashtag) {
+  mentions := []Mention{}
+  hashtags := []Hashtag{}
+  runes := []rune(body)
+
+  next := 0
+  for i := 0; i < len(runes); i++ {
+    if next < len(links) && i >= links[next].Start {
+      i = links[next].End - 1
+      next++
+      continue
+    }
+    sigil := runes[i]
+    if sigil != '@' && sigil != '#' {
+      continue
+    }
+    // Entities start a word, so an email address in a body isn't a mention
+    if i > 0 && isHandleRune(runes[i-1]) {
+      continue
+    }
+
+    inEntity := isHashtagRune
+    if sigil == '@' {
+      inEntity = isHandleRune
+    }
+    end := i + 1
+    for end < len(runes) && inEntity(runes[end]) {
+      end++
+    }
+    // Punctuation ending a sentence isn't part of the entity
+    for end > i+1 && strings.ContainsRune(".@-+%", runes[end-1]) {
+      end--
+    }
+    text := string(runes[i+1 : end])
+
+    switch {
+    case text == "":
+    case sigil == '#' && strings.IndexFunc(text, unicode.IsLetter) >= 0:
+      hashtags = append(hashtags, Hashtag{Tag: NormalizeHashtag(text), Start: i, End: end})
+    case sigil == '@':
+      if userId, ok := s.resolveHandle(text); ok {
+        mentions = append(mentions, Mention{UserId: userId, Handle: text, Start: i, End: end})
+      }
+    }
+    i = end - 1
+  }
+  return mentions, hashtags
+}
+
+// uniqueHashtags returns each tag used in hashtags once
+func uniqueHashtags(hashtags []Hashtag) []string {
+  tags := []string{}
+  seen := map[string]bool{}
+  for _, hashtag := range hashtags {
+    if !seen[hashtag.Tag] {
+      seen[hashtag.Tag] = true
+      tags = append(tags, hashtag.Tag)
+    }
+  }
+  return tags
+}
+
+// uniqueMentions returns each user mentioned in mentions once
+func uniqueMentions(mentions []Mention) []int {
+  userIds := []int{}
+  seen := map[int]bool{}
+  for _, mention := range mentions {
+    if !seen[mention.UserId] {
+      seen[mention.UserId] = true
+      userIds = append(userIds, mention.UserId)
+    }
+  }
+  return userIds
+}
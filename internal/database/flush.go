@@ -0,0 +1,138 @@
+package database
+
+import (
+  "log"
+  "time"
+  "reflect"
+)
+
+// Options controls how a DB persists its in-memory state,
+// allocates ids and migrates old data
+type Options struct {
+  // FlushInterval is how often pending changes are written to storage.
+  // Zero writes every change through before Update returns: straight
+  // to the journal for the JSON backend, whose file is then rewritten
+  // in the background, and to the changed rows for the others.
+  FlushInterval time.Duration
+  // MaxPendingWrites flushes early once this many changes are waiting.
+  // Zero only flushes on the interval.
+  MaxPendingWrites int
+  // TimeOrderedChirpIds makes chirp ids increase with creation time
+  // instead of counting up from 1
+  TimeOrderedChirpIds bool
+  // MigrationDryRun runs pending migrations in memory only and leaves
+  // storage as it was. Use it to check an upgrade, not to serve traffic.
+  MigrationDryRun bool
+}
+
+// checkpointEntries is how many journal entries pile up before the
+// flusher rewrites a journaled database, when changes are written through
+const checkpointEntries = 1000
+
+// Flush writes any pending changes to storage
+func (db *DB) Flush() error {
+  db.flushMux.Lock()
+  defer db.flushMux.Unlock()
+
+  incremental, isIncremental := db.storage.(incrementalStorage)
+  var snapshot DBStructure
+  var changes map[string]journalChange
+
+  // Only the flusher takes unflushed away, and writers can't touch it
+  // while the read lock is held
+  db.mux.RLock()
+  pending := db.pending
+  flushedSeq := db.cache.JournalSeq
+  if pending > 0 {
+    if isIncremental {
+      changes = db.unflushed
+      db.unflushed = map[string]journalChange{}
+    } else {
+      snapshot = db.cache.clone()
+    }
+  }
+  db.mux.RUnlock()
+
+  if pending == 0 {
+    return nil
+  }
+
+  var err error
+  if isIncremental {
+    list := make([]journalChange, 0, len(changes))
+    for _, change := range changes {
+      list = append(list, change)
+    }
+    err = incremental.writeChanges(list)
+  } else {
+    err = db.storage.write(snapshot)
+  }
+
+  db.mux.Lock()
+  defer db.mux.Unlock()
+  if err != nil {
+    // Put back whatever hasn't been changed again since
+    for key, change := range changes {
+      if _, ok := db.unflushed[key]; !ok {
+        db.unflushed[key] = change
+      }
+    }
+    return err
+  }
+  db.pending -= pending
+  // Entries written while flushing stay in the journal, so under steady
+  // writes it still shrinks back every flush
+  if journal, ok := db.storage.(journaledStorage); ok {
+    return journal.checkpoint(flushedSeq)
+  }
+  return nil
+}
+
+// flushLoop writes pending changes every FlushInterval, or sooner when
+// MaxPendingWrites or checkpointEntries is reached, until the DB is closed
+func (db *DB) flushLoop() {
+  defer db.flusher.Done()
+
+  // With no interval the loop only runs when it's told to
+  var tick <-chan time.Time
+  if db.options.FlushInterval > 0 {
+    ticker := time.NewTicker(db.options.FlushInterval)
+    defer ticker.Stop()
+    tick = ticker.C
+  }
+
+  for {
+    select {
+    case <-db.done:
+      return
+    case <-tick:
+    case <-db.flushNow:
+    }
+
+    if err := db.Flush(); err != nil {
+      log.Printf("Unable to flush database: %v", err)
+    }
+  }
+}
+
+// clone copies every collection so the copy can be changed without
+// affecting s. Records are copied by value. Missing collections are
+// created empty.
+func (s DBStructure) clone() DBStructure {
+  cloned := s
+  v := reflect.ValueOf(&cloned).Elem()
+  for i := 0; i < v.NumField(); i++ {
+    field := v.Field(i)
+    if field.Kind() != reflect.Map || !field.CanSet() {
+      continue
+    }
+
+    copied := reflect.MakeMapWithSize(field.Type(), field.Len())
+    iter := field.MapRange()
+    for iter.Next() {
+      copied.SetMapIndex(iter.Key(), iter.Value())
+    }
+    field.Set(copied)
+  }
+  return cloned
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+  "fmt"
+  "sort"
+  "time"
+)
+
+// Follow records one user following another
+type Follow struct {
+  Id int `json:"id"`
+  FollowerId int `json:"follower_id"`
+  FolloweeId int `json:"followee_id"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+// FollowUser makes followerId follow followeeId. Following someone
+// twice returns the first follow.
+func (db *DB) FollowUser(followerId int, followeeId int) (Follow, error) {
+  var follow Follow
+  err := db.update("FollowUser", func(dbStructure *DBStructure) error {
+    if followerId == followeeId {
+      return fmt.Errorf("Users can't follow themselves")
+    }
+    if _, ok := dbStructure.Users[followeeId]; !ok {
+      return fmt.Errorf("No user found with id: %v", followeeId)
+    }
+
+    if id, ok := dbStructure.indexes.following[followerId][followeeId]; ok {
+      follow = dbStructure.Follows[id]
+      return nil
+    }
+
+    follow = Follow{
+      Id: dbStructure.nextId("follows"),
+      FollowerId: followerId,
+      FolloweeId: followeeId,
+      CreatedAt: time.Now().UTC(),
+    }
+    followsTable.put(dbStructure, follow.Id, follow)
+    return nil
+  })
+  if err != nil {
+    return Follow{}, err
+  }
+  return follow, nil
+}
+
+// UnfollowUser stops followerId following followeeId, if they do
+func (db *DB) UnfollowUser(followerId int, followeeId int) error {
+  return db.update("UnfollowUser", func(dbStructure *DBStructure) error {
+    if id, ok := dbStructure.indexes.following[followerId][followeeId]; ok {
+      followsTable.remove(dbStructure, id)
+    }
+    return nil
+  })
+}
+
+// ListFollowers returns the users following userId, most recent first
+func (db *DB) ListFollowers(userId int) ([]User, error) {
+  return db.listFollows(userId, func(idx *indexes) map[int]map[int]int {
+    return idx.followers
+  })
+}
+
+// ListFollowing returns the users userId follows, most recent first
+func (db *DB) ListFollowing(userId int) ([]User, error) {
+  return db.listFollows(userId, func(idx *indexes) map[int]map[int]int {
+    return idx.following
+  })
+}
+
+func (db *DB) listFollows(userId int, index func(*indexes) map[int]map[int]int) ([]User, error) {
+  users := []User{}
+  err := db.View(func(dbStructure *DBStructure) error {
+    if _, ok := dbStructure.Users[userId]; !ok {
+      return fmt.Errorf("No user found with id: %v", userId)
+    }
+
+    follows := index(dbStructure.indexes)[userId]
+    otherIds := make([]int, 0, len(follows))
+    for otherId := range follows {
+      otherIds = append(otherIds, otherId)
+    }
+    sort.Slice(otherIds, func(i, j int) bool {
+      return follows[otherIds[i]] > follows[otherIds[j]]
+    })
+    for _, otherId := range otherIds {
+      users = append(users, dbStructure.Users[otherId])
+    }
+    return nil
+  })
+  if err != nil {
+    return []User{}, err
+  }
+  return users, nil
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+  "time"
+  "reflect"
+)
+
+// idEpoch is the zero point for time ordered ids
+var idEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// timeOrderedShift leaves room for 4096 ids per millisecond. With a
+// 41 bit timestamp the ids fit in 53 bits, so JavaScript clients can
+// still treat them as numbers.
+const timeOrderedShift = 12
+
+// nextId allocates the next id for a collection. The counter is stored
+// with the data, so ids are never reused even after a delete.
+func (s *DBStructure) nextId(collection string) int {
+  s.touchSequence(collection)
+  s.Sequences[collection]++
+  return s.Sequences[collection]
+}
+
+// nextTimeOrderedId allocates an id that sorts by creation time. It
+// never goes backwards, even if the clock does.
+func (s *DBStructure) nextTimeOrderedId(collection string, now time.Time) int {
+  s.touchSequence(collection)
+
+  id := int(now.Sub(idEpoch).Milliseconds()) << timeOrderedShift
+  if id <= s.Sequences[collection] {
+    id = s.Sequences[collection] + 1
+  }
+  s.Sequences[collection] = id
+  return id
+}
+
+// initSequences starts each collection's counter after its highest
+// existing id, for data written before counters were stored
+func (s *DBStructure) initSequences() {
+  if s.Sequences == nil {
+    s.Sequences = map[string]int{}
+  }
+
+  v := reflect.ValueOf(s).Elem()
+  t := v.Type()
+  for i := 0; i < t.NumField(); i++ {
+    field := v.Field(i)
+    if field.Kind() != reflect.Map || field.Type().Key().Kind() != reflect.Int {
+      continue
+    }
+    name := jsonName(t.Field(i))
+    if name == "" {
+      continue
+    }
+
+    iter := field.MapRange()
+    for iter.Next() {
+      if id := int(iter.Key().Int()); id > s.Sequences[name] {
+        s.Sequences[name] = id
+      }
+    }
+  }
+}
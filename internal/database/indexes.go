@@ -0,0 +1,569 @@
+package database
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+)
+
+// indexes are secondary lookups over a DBStructure. They are built
+// when the database is loaded, and every update after that reindexes
+// just the records it changed.
+type indexes struct {
+  usersByEmail map[string]int
+  usersByRefreshToken map[string]int
+  // usersByHandle holds the ids of the users whose email starts with
+  // each lower cased handle, the part before the @, in ascending order
+  usersByHandle map[string][]int
+  // chirpIds holds the ids of every listed chirp, in ascending order
+  chirpIds []int
+  // chirpsByAuthor holds the ids of each author's listed chirps,
+  // in ascending order
+  chirpsByAuthor map[int][]int
+  // chirpsByAttachment maps the blob key of each attachment to the
+  // chirp it's attached to, listed or not
+  chirpsByAttachment map[string]int
+  // revisionsByChirp holds each chirp's revision ids, oldest first
+  revisionsByChirp map[int][]int
+  // chirpsByHashtag and chirpsByMention hold the ids of the listed
+  // chirps using each hashtag and mentioning each user, ascending
+  chirpsByHashtag map[string][]int
+  chirpsByMention map[int][]int
+  // repliesByChirp holds the ids of the replies to each chirp, listed
+  // or not, oldest first
+  repliesByChirp map[int][]int
+  // likesByChirp maps each chirp to the users who like it and the
+  // ids of their likes
+  likesByChirp map[int]map[int]int
+  // rechirpsByChirp maps each listed chirp to the users who rechirped
+  // it and the ids of their listed rechirps
+  rechirpsByChirp map[int]map[int]int
+  // allRechirpsByChirp holds the ids of every rechirp of each chirp,
+  // listed or not, oldest first
+  allRechirpsByChirp map[int][]int
+  // rechirpsByUser maps each chirp to the users who rechirped it and
+  // the ids of their rechirps, listed or not
+  rechirpsByUser map[int]map[int]int
+  // followers maps each user to their followers and the ids of their
+  // follows, and following maps each user to who they follow
+  followers map[int]map[int]int
+  following map[int]map[int]int
+  // conversationsByPair holds the conversation between each pair of users
+  conversationsByPair map[[2]int]int
+  // conversationsByActivity holds each user's conversations ordered by
+  // activity, least recently active first, and conversationActivity
+  // where each conversation is in its participants' lists
+  conversationsByActivity map[int][]activity
+  conversationActivity map[int]activityEntry
+  // messagesByConversation holds each conversation's message ids,
+  // oldest first
+  messagesByConversation map[int][]int
+  // readReceipts maps each conversation to its participants' receipt ids
+  readReceipts map[int]map[int]int
+  // openReportsByChirp holds the ids of each chirp's open reports,
+  // oldest first
+  openReportsByChirp map[int][]int
+  // notificationsByUser holds the ids of each user's notifications,
+  // oldest first
+  notificationsByUser map[int][]int
+  // unreadNotifications maps each event a user has an unread
+  // notification of to that notification's id
+  unreadNotifications map[notificationKey]int
+  // linkPreviewsByUrl maps each previewed URL, as previewKey returns
+  // it, to its preview's id
+  linkPreviewsByUrl map[string]int
+}
+
+func newIndexes() *indexes {
+  return &indexes{
+    usersByEmail: map[string]int{},
+    usersByRefreshToken: map[string]int{},
+    usersByHandle: map[string][]int{},
+    chirpIds: []int{},
+    chirpsByAuthor: map[int][]int{},
+    chirpsByAttachment: map[string]int{},
+    revisionsByChirp: map[int][]int{},
+    repliesByChirp: map[int][]int{},
+    chirpsByHashtag: map[string][]int{},
+    chirpsByMention: map[int][]int{},
+    likesByChirp: map[int]map[int]int{},
+    rechirpsByChirp: map[int]map[int]int{},
+    allRechirpsByChirp: map[int][]int{},
+    rechirpsByUser: map[int]map[int]int{},
+    followers: map[int]map[int]int{},
+    following: map[int]map[int]int{},
+    conversationsByPair: map[[2]int]int{},
+    conversationsByActivity: map[int][]activity{},
+    conversationActivity: map[int]activityEntry{},
+    messagesByConversation: map[int][]int{},
+    readReceipts: map[int]map[int]int{},
+    openReportsByChirp: map[int][]int{},
+    notificationsByUser: map[int][]int{},
+    unreadNotifications: map[notificationKey]int{},
+    linkPreviewsByUrl: map[string]int{},
+  }
+}
+
+// buildIndexes rebuilds every index, failing if a unique one
+// would have duplicate keys
+func (s *DBStructure) buildIndexes() error {
+  idx := newIndexes()
+  for _, err := range []error{
+    indexTable(idx, s, usersTable),
+    indexTable(idx, s, chirpsTable),
+    indexTable(idx, s, revisionsTable),
+    indexTable(idx, s, likesTable),
+    indexTable(idx, s, followsTable),
+    indexTable(idx, s, conversationsTable),
+    indexTable(idx, s, messagesTable),
+    indexTable(idx, s, readReceiptsTable),
+    indexTable(idx, s, reportsTable),
+    indexTable(idx, s, notificationsTable),
+    indexTable(idx, s, linkPreviewsTable),
+  } {
+    if err != nil {
+      return err
+    }
+  }
+  s.indexes = idx
+  return nil
+}
+
+// indexTable adds every record in a table in ascending id order,
+// so the sorted id lists are built by appending
+func indexTable[T interface{}](idx *indexes, s *DBStructure, t table[T]) error {
+  records := t.records(s)
+  ids := make([]int, 0, len(records))
+  for id := range records {
+    ids = append(ids, id)
+  }
+  sort.Ints(ids)
+
+  for _, id := range ids {
+    if err := idx.add(s, id, records[id]); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// update reindexes the records tx changed, which have already been
+// changed in s. Every old entry is taken out before any new one goes
+// in, so records can swap unique keys within an update.
+func (idx *indexes) update(s *DBStructure, tx *tx) error {
+  // Rechirps are listed only while the chirp they repost is, so they
+  // are reindexed when it's listed or unlisted even if they didn't change
+  rechirps := []int{}
+  for _, c := range tx.order {
+    if c.table != chirpsTable.name {
+      continue
+    }
+    wasListed := c.existed && c.before.(Chirp).IsListed()
+    listed := c.exists && c.after.(Chirp).IsListed()
+    if wasListed == listed {
+      continue
+    }
+    for _, rechirpId := range idx.allRechirpsByChirp[c.id] {
+      if _, changed := tx.changes[changeKey{chirpsTable.name, rechirpId}]; !changed {
+        rechirps = append(rechirps, rechirpId)
+      }
+    }
+  }
+
+  for _, c := range tx.order {
+    if c.existed {
+      idx.remove(c.id, c.before)
+    }
+  }
+  for _, rechirpId := range rechirps {
+    idx.remove(rechirpId, s.Chirps[rechirpId])
+  }
+
+  for _, c := range tx.order {
+    if c.exists {
+      if err := idx.add(s, c.id, c.after); err != nil {
+        return err
+      }
+    }
+  }
+  for _, rechirpId := range rechirps {
+    if err := idx.add(s, rechirpId, s.Chirps[rechirpId]); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// add indexes a record under the keys it has in s, failing if a
+// unique key is already taken
+func (idx *indexes) add(s *DBStructure, id int, record interface{}) error {
+  switch record := record.(type) {
+  case User:
+    if otherId, ok := idx.usersByEmail[record.Email]; ok && otherId != id {
+      return fmt.Errorf("Email already in use: %v", record.Email)
+    }
+    idx.usersByEmail[record.Email] = id
+    addId(idx.usersByHandle, handleOf(record), id)
+    if record.RefreshToken.RefreshToken != "" {
+      idx.usersByRefreshToken[record.RefreshToken.RefreshToken] = id
+    }
+
+  case Chirp:
+    if record.InReplyTo != 0 {
+      addId(idx.repliesByChirp, record.InReplyTo, id)
+    }
+    for _, attachment := range record.Attachments {
+      idx.chirpsByAttachment[attachment.Key] = id
+    }
+    if record.RechirpOf != 0 {
+      addId(idx.allRechirpsByChirp, record.RechirpOf, id)
+      addUserId(idx.rechirpsByUser, record.RechirpOf, record.AuthorId, id)
+    }
+    if !s.isListed(record) {
+      return nil
+    }
+    if record.RechirpOf != 0 {
+      addUserId(idx.rechirpsByChirp, record.RechirpOf, record.AuthorId, id)
+    }
+    idx.chirpIds = insertId(idx.chirpIds, id)
+    for _, hashtag := range uniqueHashtags(record.Hashtags) {
+      addId(idx.chirpsByHashtag, hashtag, id)
+    }
+    for _, userId := range uniqueMentions(record.Mentions) {
+      addId(idx.chirpsByMention, userId, id)
+    }
+    addId(idx.chirpsByAuthor, record.AuthorId, id)
+
+  case ChirpRevision:
+    addId(idx.revisionsByChirp, record.ChirpId, id)
+
+  case Like:
+    addUserId(idx.likesByChirp, record.ChirpId, record.UserId, id)
+
+  case Follow:
+    addUserId(idx.followers, record.FolloweeId, record.FollowerId, id)
+    addUserId(idx.following, record.FollowerId, record.FolloweeId, id)
+
+  case Conversation:
+    if len(record.ParticipantIds) != 2 {
+      return fmt.Errorf("Conversation %v doesn't have two participants", id)
+    }
+    key := pairKey(record.ParticipantIds[0], record.ParticipantIds[1])
+    if otherId, ok := idx.conversationsByPair[key]; ok && otherId != id {
+      return fmt.Errorf("Users %v and %v already have a conversation", key[0], key[1])
+    }
+    idx.conversationsByPair[key] = id
+    idx.conversationActivity[id] = activityEntry{participantIds: record.ParticipantIds}
+    idx.updateActivity(id)
+
+  case Message:
+    addId(idx.messagesByConversation, record.ConversationId, id)
+    idx.updateActivity(record.ConversationId)
+
+  case ReadReceipt:
+    addUserId(idx.readReceipts, record.ConversationId, record.UserId, id)
+
+  case Report:
+    if record.IsOpen() {
+      addId(idx.openReportsByChirp, record.ChirpId, id)
+    }
+
+  case Notification:
+    addId(idx.notificationsByUser, record.UserId, id)
+    if !record.IsRead() {
+      idx.unreadNotifications[record.key()] = id
+    }
+
+  case LinkPreview:
+    key := previewKey(record.Url)
+    if otherId, ok := idx.linkPreviewsByUrl[key]; ok && otherId != id {
+      return fmt.Errorf("Link %v already has a preview", record.Url)
+    }
+    idx.linkPreviewsByUrl[key] = id
+  }
+  return nil
+}
+
+// remove takes a record out of the indexes, given the record as it
+// was when it was added
+func (idx *indexes) remove(id int, record interface{}) {
+  switch record := record.(type) {
+  case User:
+    if idx.usersByEmail[record.Email] == id {
+      delete(idx.usersByEmail, record.Email)
+    }
+    removeId(idx.usersByHandle, handleOf(record), id)
+    if idx.usersByRefreshToken[record.RefreshToken.RefreshToken] == id {
+      delete(idx.usersByRefreshToken, record.RefreshToken.RefreshToken)
+    }
+
+  case Chirp:
+    // Whether it was listed depends on the chirp it reposts, which may
+    // have changed too, so it's taken out of the listed indexes either way
+    removeId(idx.repliesByChirp, record.InReplyTo, id)
+    for _, attachment := range record.Attachments {
+      if idx.chirpsByAttachment[attachment.Key] == id {
+        delete(idx.chirpsByAttachment, attachment.Key)
+      }
+    }
+    removeId(idx.allRechirpsByChirp, record.RechirpOf, id)
+    removeUserId(idx.rechirpsByUser, record.RechirpOf, record.AuthorId, id)
+    removeUserId(idx.rechirpsByChirp, record.RechirpOf, record.AuthorId, id)
+    idx.chirpIds = deleteId(idx.chirpIds, id)
+    for _, hashtag := range uniqueHashtags(record.Hashtags) {
+      removeId(idx.chirpsByHashtag, hashtag, id)
+    }
+    for _, userId := range uniqueMentions(record.Mentions) {
+      removeId(idx.chirpsByMention, userId, id)
+    }
+    removeId(idx.chirpsByAuthor, record.AuthorId, id)
+
+  case ChirpRevision:
+    removeId(idx.revisionsByChirp, record.ChirpId, id)
+
+  case Like:
+    removeUserId(idx.likesByChirp, record.ChirpId, record.UserId, id)
+
+  case Follow:
+    removeUserId(idx.followers, record.FolloweeId, record.FollowerId, id)
+    removeUserId(idx.following, record.FollowerId, record.FolloweeId, id)
+
+  case Conversation:
+    if len(record.ParticipantIds) == 2 {
+      key := pairKey(record.ParticipantIds[0], record.ParticipantIds[1])
+      if idx.conversationsByPair[key] == id {
+        delete(idx.conversationsByPair, key)
+      }
+    }
+    if entry, ok := idx.conversationActivity[id]; ok {
+      for _, userId := range entry.participantIds {
+        removeActivity(idx.conversationsByActivity, userId, entry.activity)
+      }
+      delete(idx.conversationActivity, id)
+    }
+
+  case Message:
+    removeId(idx.messagesByConversation, record.ConversationId, id)
+    idx.updateActivity(record.ConversationId)
+
+  case ReadReceipt:
+    removeUserId(idx.readReceipts, record.ConversationId, record.UserId, id)
+
+  case Report:
+    removeId(idx.openReportsByChirp, record.ChirpId, id)
+
+  case Notification:
+    removeId(idx.notificationsByUser, record.UserId, id)
+    if idx.unreadNotifications[record.key()] == id {
+      delete(idx.unreadNotifications, record.key())
+    }
+
+  case LinkPreview:
+    key := previewKey(record.Url)
+    if idx.linkPreviewsByUrl[key] == id {
+      delete(idx.linkPreviewsByUrl, key)
+    }
+  }
+}
+
+// activity orders conversations by their newest message. Message ids
+// go up over time and never repeat, so they order conversations the way
+// the messages' times would, without ties. Conversations with no
+// messages come before any that have them, ordered by their own id.
+type activity struct {
+  lastMessageId int
+  conversationId int
+}
+
+func (a activity) less(other activity) bool {
+  if a.lastMessageId != other.lastMessageId {
+    return a.lastMessageId < other.lastMessageId
+  }
+  return a.conversationId < other.conversationId
+}
+
+// activityEntry is where a conversation is in its participants' lists
+type activityEntry struct {
+  activity activity
+  participantIds []int
+}
+
+// updateActivity moves a conversation to where its newest message puts
+// it in its participants' lists, once the conversation is indexed
+func (idx *indexes) updateActivity(conversationId int) {
+  entry, ok := idx.conversationActivity[conversationId]
+  if !ok {
+    return
+  }
+  next := activity{conversationId: conversationId}
+  if messageIds := idx.messagesByConversation[conversationId]; len(messageIds) > 0 {
+    next.lastMessageId = messageIds[len(messageIds)-1]
+  }
+  if entry.activity == next {
+    return
+  }
+
+  for _, userId := range entry.participantIds {
+    removeActivity(idx.conversationsByActivity, userId, entry.activity)
+    addActivity(idx.conversationsByActivity, userId, next)
+  }
+  entry.activity = next
+  idx.conversationActivity[conversationId] = entry
+}
+
+// searchActivity returns where a belongs in a list ordered by activity
+func searchActivity(list []activity, a activity) int {
+  return sort.Search(len(list), func(i int) bool { return !list[i].less(a) })
+}
+
+// addActivity adds a to userId's list, keeping it in order
+func addActivity(lists map[int][]activity, userId int, a activity) {
+  list := lists[userId]
+  i := searchActivity(list, a)
+  if i < len(list) && list[i] == a {
+    return
+  }
+  list = append(list, activity{})
+  copy(list[i+1:], list[i:])
+  list[i] = a
+  lists[userId] = list
+}
+
+// removeActivity takes a out of userId's list, dropping the list once
+// it's empty
+func removeActivity(lists map[int][]activity, userId int, a activity) {
+  list, ok := lists[userId]
+  if !ok {
+    return
+  }
+  i := searchActivity(list, a)
+  if i == len(list) || list[i] != a {
+    return
+  }
+  if list = append(list[:i], list[i+1:]...); len(list) == 0 {
+    delete(lists, userId)
+  } else {
+    lists[userId] = list
+  }
+}
+
+// handleOf returns the lower cased part of a user's email before the @
+func handleOf(user User) string {
+  return strings.ToLower(strings.SplitN(user.Email, "@", 2)[0])
+}
+
+// insertId adds id to a sorted list of ids, keeping it sorted
+func insertId(ids []int, id int) []int {
+  i := sort.SearchInts(ids, id)
+  if i < len(ids) && ids[i] == id {
+    return ids
+  }
+  ids = append(ids, 0)
+  copy(ids[i+1:], ids[i:])
+  ids[i] = id
+  return ids
+}
+
+// deleteId takes id out of a sorted list of ids
+func deleteId(ids []int, id int) []int {
+  i := sort.SearchInts(ids, id)
+  if i == len(ids) || ids[i] != id {
+    return ids
+  }
+  return append(ids[:i], ids[i+1:]...)
+}
+
+// addId adds id to the sorted list under key
+func addId[K comparable](lists map[K][]int, key K, id int) {
+  lists[key] = insertId(lists[key], id)
+}
+
+// removeId takes id out of the sorted list under key, dropping the
+// key once its list is empty
+func removeId[K comparable](lists map[K][]int, key K, id int) {
+  ids, ok := lists[key]
+  if !ok {
+    return
+  }
+  if ids = deleteId(ids, id); len(ids) == 0 {
+    delete(lists, key)
+  } else {
+    lists[key] = ids
+  }
+}
+
+// addUserId records id as userId's record under key
+func addUserId(maps map[int]map[int]int, key int, userId int, id int) {
+  if maps[key] == nil {
+    maps[key] = map[int]int{}
+  }
+  maps[key][userId] = id
+}
+
+// removeUserId drops userId's record under key if it's still id,
+// dropping the key once nobody is left under it
+func removeUserId(maps map[int]map[int]int, key int, userId int, id int) {
+  if recordId, ok := maps[key][userId]; !ok || recordId != id {
+    return
+  }
+  delete(maps[key], userId)
+  if len(maps[key]) == 0 {
+    delete(maps, key)
+  }
+}
+
+// findUserByEmail returns the user with the given email,
+// or an empty User if there isn't one
+func (s *DBStructure) findUserByEmail(email string) User {
+  if id, ok := s.indexes.usersByEmail[email]; ok {
+    return s.Users[id]
+  }
+  return User{}
+}
+
+// findUserByRefreshToken returns the user holding refreshToken,
+// or an empty User if nobody does
+func (s *DBStructure) findUserByRefreshToken(refreshToken string) User {
+  if id, ok := s.indexes.usersByRefreshToken[refreshToken]; ok {
+    return s.Users[id]
+  }
+  return User{}
+}
+
+// chirpsByAuthor returns an author's listed chirps,
+// in ascending id order
+func (s *DBStructure) chirpsByAuthor(authorId int) []Chirp {
+  ids := s.indexes.chirpsByAuthor[authorId]
+  chirps := make([]Chirp, 0, len(ids))
+  for _, id := range ids {
+    chirps = append(chirps, s.expand(s.Chirps[id]))
+  }
+  return chirps
+}
+
+// expand fills in the fields a chirp is returned with that are worked
+// out from the indexes when it's read rather than stored: its counts
+// and, for a rechirp, the chirp it reposts
+func (s *DBStructure) expand(chirp Chirp) Chirp {
+  chirp.ReplyCount = 0
+  for _, replyId := range s.indexes.repliesByChirp[chirp.Id] {
+    if s.Chirps[replyId].IsListed() {
+      chirp.ReplyCount++
+    }
+  }
+  if chirp.Attachments == nil {
+    chirp.Attachments = []Attachment{}
+  }
+  chirp.Links = s.previewLinks(chirp.Links)
+  chirp.LikeCount = len(s.indexes.likesByChirp[chirp.Id])
+  chirp.RechirpCount = len(s.indexes.rechirpsByChirp[chirp.Id])
+
+  chirp.Rechirped = nil
+  if chirp.RechirpOf != 0 {
+    if original, ok := s.Chirps[chirp.RechirpOf]; ok && original.IsListed() {
+      original = s.expand(original)
+      chirp.Rechirped = &original
+    }
+  }
+  return chirp
+}
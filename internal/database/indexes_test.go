@@ -0,0 +1,156 @@
+package database
+
+import (
+  "fmt"
+  "time"
+  "reflect"
+  "testing"
+  "math/rand"
+)
+
+// TestIndexesMatchRebuild makes random changes and checks after each
+// one that the indexes updates kept up to date match a full rebuild
+func TestIndexesMatchRebuild(t *testing.T) {
+  db, err := NewMemoryDB()
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer db.Close()
+
+  users := 6
+  for i := 0; i < users; i++ {
+    if _, err := db.CreateUser(User{Email: fmt.Sprintf("user%d@example.com", i)}); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  random := rand.New(rand.NewSource(1))
+  user := func() int { return random.Intn(users) + 1 }
+  chirp := func() int {
+    var id int
+    db.View(func(dbStructure *DBStructure) error {
+      id = random.Intn(dbStructure.Sequences["chirps"] + 1)
+      return nil
+    })
+    return id
+  }
+
+  ops := []func() error{
+    func() error {
+      _, err := db.CreateChirp(fmt.Sprintf("Hello @user%d #tag%d", random.Intn(users), random.Intn(3)), user())
+      return err
+    },
+    func() error {
+      _, err := db.CreateReply("A reply #tag1", user(), chirp())
+      return err
+    },
+    func() error {
+      _, err := db.EditChirp(chirp(), fmt.Sprintf("Edited for @user%d #tag%d", random.Intn(users), random.Intn(3)))
+      return err
+    },
+    func() error { return db.DeleteChirp(chirp(), user()) },
+    func() error {
+      _, err := db.UndeleteChirp(chirp())
+      return err
+    },
+    func() error {
+      _, _, err := db.LikeChirp(chirp(), user())
+      return err
+    },
+    func() error {
+      _, _, err := db.UnlikeChirp(chirp(), user())
+      return err
+    },
+    func() error {
+      _, _, err := db.Rechirp(chirp(), user())
+      return err
+    },
+    func() error {
+      _, _, err := db.Unrechirp(chirp(), user())
+      return err
+    },
+    func() error {
+      _, err := db.ReportChirp(chirp(), user(), "spam")
+      return err
+    },
+    func() error {
+      actions := []string{ModerationHide, ModerationDelete, ModerationDismiss}
+      _, err := db.ModerateChirp(chirp(), user(), actions[random.Intn(len(actions))], "")
+      return err
+    },
+    func() error {
+      _, err := db.FollowUser(user(), user())
+      return err
+    },
+    func() error { return db.UnfollowUser(user(), user()) },
+    func() error {
+      conversation, err := db.CreateConversation(user(), user())
+      if err != nil {
+        return err
+      }
+      message, err := db.SendMessage(conversation.Id, conversation.ParticipantIds[0], "Hi")
+      if err != nil {
+        return err
+      }
+      _, err = db.MarkConversationRead(conversation.Id, conversation.ParticipantIds[1], message.Id)
+      return err
+    },
+    func() error {
+      _, _, err := db.Notify(Notification{UserId: user(), Type: NotificationFollow, ActorId: user()})
+      return err
+    },
+    func() error {
+      _, err := db.MarkAllNotificationsRead(user())
+      return err
+    },
+    func() error {
+      _, err := db.SaveLinkPreview(LinkPreview{Url: fmt.Sprintf("https://example.com/%d", random.Intn(3))})
+      return err
+    },
+    func() error {
+      found, err := db.FindUserById(user())
+      if err != nil {
+        return err
+      }
+      _, err = db.CreateRefreshToken(found, RefreshToken{RefreshToken: fmt.Sprintf("token%d", random.Int())})
+      return err
+    },
+    func() error {
+      // Taken emails fail the unique index and roll back
+      found, err := db.FindUserById(user())
+      if err != nil {
+        return err
+      }
+      found.Email = fmt.Sprintf("user%d@example.com", random.Intn(users+2))
+      _, err = db.UpdateUser(found)
+      return err
+    },
+    func() error {
+      // Rarely, so deleted chirps get a chance to be undeleted
+      if random.Intn(10) != 0 {
+        return nil
+      }
+      _, err := db.PurgeChirps(time.Now().Add(time.Hour))
+      return err
+    },
+  }
+
+  for i := 0; i < 2000; i++ {
+    op := random.Intn(len(ops))
+    ops[op]()
+
+    db.View(func(dbStructure *DBStructure) error {
+      rebuilt := *dbStructure
+      if err := rebuilt.buildIndexes(); err != nil {
+        t.Fatalf("Step %v: rebuilding failed: %v", i, err)
+      }
+      if !reflect.DeepEqual(dbStructure.indexes, rebuilt.indexes) {
+        t.Fatalf("Step %v: op %v left indexes that don't match a rebuild:\n%+v\n%+v", i, op, dbStructure.indexes, rebuilt.indexes)
+      }
+      if !reflect.DeepEqual(db.search, buildSearchIndex(dbStructure.Chirps)) {
+        t.Fatalf("Step %v: op %v left a search index that doesn't match a rebuild", i, op)
+      }
+      return nil
+    })
+  }
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+  "fmt"
+  "os"
+  "bufio"
+  "bytes"
+  "reflect"
+  "strconv"
+  "encoding/json"
+)
+
+// journalEntry is one mutation appended to the journal. Changes hold
+// the records the mutation left behind, so replaying an entry twice
+// gives the same result.
+type journalEntry struct {
+  Seq int `json:"seq"`
+  Op string `json:"op"`
+  Changes []journalChange `json:"changes"`
+}
+
+// journalChange replaces or deletes one record in a collection, or
+// replaces a top level field when Id is empty
+type journalChange struct {
+  Table string `json:"table"`
+  Id string `json:"id,omitempty"`
+  Data json.RawMessage `json:"data,omitempty"`
+  Delete bool `json:"delete,omitempty"`
+}
+
+// JournalPath returns the path of the journal kept next to a database file
+func JournalPath(path string) string {
+  return path + ".journal"
+}
+
+// splitStructure encodes each top level field of a DBStructure separately
+func splitStructure(dbStructure DBStructure) (map[string]json.RawMessage, error) {
+  data, err := json.Marshal(dbStructure)
+  if err != nil {
+    return nil, err
+  }
+
+  fields := map[string]json.RawMessage{}
+  if err := json.Unmarshal(data, &fields); err != nil {
+    return nil, err
+  }
+  return fields, nil
+}
+
+// splitCollection decodes an encoded collection into its records
+func splitCollection(data json.RawMessage) (map[string]json.RawMessage, error) {
+  records := map[string]json.RawMessage{}
+  if len(data) == 0 || string(data) == "null" {
+    return records, nil
+  }
+  if err := json.Unmarshal(data, &records); err != nil {
+    return nil, err
+  }
+  return records, nil
+}
+
+func isCollection(name string) bool {
+  for _, collection := range collections() {
+    if collection == name {
+      return true
+    }
+  }
+  return false
+}
+
+// diffStructures lists the changes that turn before into after
+func diffStructures(before DBStructure, after DBStructure) ([]journalChange, error) {
+  beforeFields, err := splitStructure(before)
+  if err != nil {
+    return nil, err
+  }
+  afterFields, err := splitStructure(after)
+  if err != nil {
+    return nil, err
+  }
+
+  changes := []journalChange{}
+  for name, afterData := range afterFields {
+    if !isCollection(name) {
+      if !bytes.Equal(beforeFields[name], afterData) {
+        changes = append(changes, journalChange{Table: name, Data: afterData})
+      }
+      continue
+    }
+
+    beforeRecords, err := splitCollection(beforeFields[name])
+    if err != nil {
+      return nil, err
+    }
+    afterRecords, err := splitCollection(afterData)
+    if err != nil {
+      return nil, err
+    }
+
+    for id, record := range afterRecords {
+      if !bytes.Equal(beforeRecords[id], record) {
+        changes = append(changes, journalChange{Table: name, Id: id, Data: record})
+      }
+    }
+    for id := range beforeRecords {
+      if _, ok := afterRecords[id]; !ok {
+        changes = append(changes, journalChange{Table: name, Id: id, Delete: true})
+      }
+    }
+  }
+
+  return changes, nil
+}
+
+// applyChanges replays journal changes on top of a DBStructure,
+// decoding each changed record straight into its collection so replay
+// costs as much as the changes rather than the whole database. Changes
+// to fields DBStructure no longer has are skipped, as decoding the
+// whole file would.
+func applyChanges(dbStructure *DBStructure, changes []journalChange) error {
+  v := reflect.ValueOf(dbStructure).Elem()
+  fields := map[string]reflect.Value{}
+  for i := 0; i < v.NumField(); i++ {
+    if name := jsonName(v.Type().Field(i)); name != "" && v.Field(i).CanSet() {
+      fields[name] = v.Field(i)
+    }
+  }
+
+  for _, change := range changes {
+    field, ok := fields[change.Table]
+    if !ok {
+      continue
+    }
+    if field.Kind() != reflect.Map {
+      if err := json.Unmarshal(change.Data, field.Addr().Interface()); err != nil {
+        return fmt.Errorf("Unable to replay %v: %v", change.Table, err)
+      }
+      continue
+    }
+
+    if field.IsNil() {
+      field.Set(reflect.MakeMap(field.Type()))
+    }
+    key := reflect.New(field.Type().Key()).Elem()
+    switch key.Kind() {
+    case reflect.Int:
+      id, err := strconv.Atoi(change.Id)
+      if err != nil {
+        return fmt.Errorf("Unable to replay %v %v: %v", change.Table, change.Id, err)
+      }
+      key.SetInt(int64(id))
+    case reflect.String:
+      key.SetString(change.Id)
+    default:
+      return fmt.Errorf("Unable to replay %v: unsupported key type", change.Table)
+    }
+
+    if change.Delete {
+      field.SetMapIndex(key, reflect.Value{})
+      continue
+    }
+    record := reflect.New(field.Type().Elem())
+    if err := json.Unmarshal(change.Data, record.Interface()); err != nil {
+      return fmt.Errorf("Unable to replay %v %v: %v", change.Table, change.Id, err)
+    }
+    field.SetMapIndex(key, record.Elem())
+  }
+  return nil
+}
+
+// appendJournal writes an entry to the end of the journal and syncs it
+func appendJournal(path string, entry journalEntry) error {
+  data, err := json.Marshal(entry)
+  if err != nil {
+    return err
+  }
+
+  file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+  if err != nil {
+    return err
+  }
+
+  if _, err := file.Write(append(data, '\n')); err != nil {
+    file.Close()
+    return err
+  }
+  if err := file.Sync(); err != nil {
+    file.Close()
+    return err
+  }
+  return file.Close()
+}
+
+// readJournal returns every complete entry in the journal. A torn
+// last line from a crash mid-append is ignored.
+func readJournal(path string) ([]journalEntry, error) {
+  file, err := os.Open(path)
+  if os.IsNotExist(err) {
+    return []journalEntry{}, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  entries := []journalEntry{}
+  reader := bufio.NewReader(file)
+  for {
+    line, err := reader.ReadBytes('\n')
+    if err != nil {
+      // Anything without a trailing newline never finished writing
+      break
+    }
+
+    var entry journalEntry
+    if err := json.Unmarshal(line, &entry); err != nil {
+      break
+    }
+    entries = append(entries, entry)
+  }
+
+  return entries, nil
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "encoding/json"
+)
+
+// jsonStorage keeps the database in a single JSON file. Every
+// mutation is appended to a journal, and the file is only replaced
+// once enough of them have piled up. The journal is replayed on
+// startup to recover whatever the file is missing.
+type jsonStorage struct {
+  path string
+}
+
+// ensure creates a new database file if it doesn't exist
+// and replays any journal entries the file is missing
+func (s *jsonStorage) ensure() error {
+  _, err := os.ReadFile(s.path)
+  if os.IsNotExist(err) {
+    if err := writeFileAtomic(s.path, []byte(`{"chirps": {}, "users": {}}`)); err != nil {
+      return err
+    }
+  } else if err != nil {
+    return err
+  }
+  return s.replayJournal()
+}
+
+// exists reports whether the database file is there
+func (s *jsonStorage) exists() (bool, error) {
+  _, err := os.Stat(s.path)
+  if os.IsNotExist(err) {
+    return false, nil
+  }
+  return err == nil, err
+}
+
+// replayJournal applies journal entries newer than the database file,
+// writes the result and then starts a fresh journal
+func (s *jsonStorage) replayJournal() error {
+  if _, err := os.Stat(JournalPath(s.path)); os.IsNotExist(err) {
+    return nil
+  }
+
+  dbStructure, err := s.load()
+  if err != nil {
+    return fmt.Errorf("Unable to replay journal onto %v: %v", s.path, err)
+  }
+
+  dbStructure, replayed, err := s.replay(dbStructure)
+  if err != nil {
+    return err
+  }
+  if replayed {
+    if err := s.write(dbStructure); err != nil {
+      return err
+    }
+  }
+  return s.checkpoint(dbStructure.JournalSeq)
+}
+
+// replay applies the journal entries newer than dbStructure to it
+func (s *jsonStorage) replay(dbStructure DBStructure) (DBStructure, bool, error) {
+  entries, err := readJournal(JournalPath(s.path))
+  if err != nil {
+    return DBStructure{}, false, err
+  }
+
+  replayed := false
+  for _, entry := range entries {
+    if entry.Seq <= dbStructure.JournalSeq {
+      continue
+    }
+    if err := applyChanges(&dbStructure, entry.Changes); err != nil {
+      return DBStructure{}, false, err
+    }
+    dbStructure.JournalSeq = entry.Seq
+    replayed = true
+  }
+  return dbStructure, replayed, nil
+}
+
+// checkpoint drops the journal entries up to seq, which the database
+// file holds, keeping any written since
+func (s *jsonStorage) checkpoint(seq int) error {
+  entries, err := readJournal(JournalPath(s.path))
+  if err != nil {
+    return err
+  }
+
+  var kept []byte
+  for _, entry := range entries {
+    if entry.Seq <= seq {
+      continue
+    }
+    data, err := json.Marshal(entry)
+    if err != nil {
+      return err
+    }
+    kept = append(append(kept, data...), '\n')
+  }
+
+  if len(kept) == 0 {
+    if err := os.Remove(JournalPath(s.path)); err != nil && !os.IsNotExist(err) {
+      return err
+    }
+    return nil
+  }
+  return writeFileAtomic(JournalPath(s.path), kept)
+}
+
+// journal appends a mutation's changes to the journal
+func (s *jsonStorage) journal(op string, seq int, changes []journalChange) error {
+  return appendJournal(JournalPath(s.path), journalEntry{
+    Seq: seq,
+    Op: op,
+    Changes: changes,
+  })
+}
+
+// load reads the database file into memory
+func (s *jsonStorage) load() (DBStructure, error) {
+  data, err := os.ReadFile(s.path)
+  if err != nil {
+    return DBStructure{}, err
+  }
+
+  var dbStructure DBStructure
+  if err := json.Unmarshal(data, &dbStructure); err != nil {
+    return DBStructure{}, err
+  }
+
+  return dbStructure, nil
+}
+
+// write writes the database file to disk
+func (s *jsonStorage) write(dbStructure DBStructure) error {
+  data, err := json.Marshal(dbStructure)
+  if err != nil {
+    return err
+  }
+
+  return writeFileAtomic(s.path, data)
+}
+
+// backup copies the database file to dest
+func (s *jsonStorage) backup(dest string) error {
+  data, err := os.ReadFile(s.path)
+  if err != nil {
+    return err
+  }
+  return writeFileAtomic(dest, data)
+}
+
+func (s *jsonStorage) close() error {
+  return nil
+}
+
+// writeFileAtomic writes data to a temp file in the same directory,
+// syncs it and renames it over path, so readers only ever see the
+// old or the new contents
+func writeFileAtomic(path string, data []byte) error {
+  dir := filepath.Dir(path)
+  tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+  if err != nil {
+    return err
+  }
+  tmpPath := tmp.Name()
+
+  if _, err := tmp.Write(data); err != nil {
+    tmp.Close()
+    os.Remove(tmpPath)
+    return err
+  }
+  if err := tmp.Sync(); err != nil {
+    tmp.Close()
+    os.Remove(tmpPath)
+    return err
+  }
+  if err := tmp.Close(); err != nil {
+    os.Remove(tmpPath)
+    return err
+  }
+  if err := os.Chmod(tmpPath, 0644); err != nil {
+    os.Remove(tmpPath)
+    return err
+  }
+
+  if err := os.Rename(tmpPath, path); err != nil {
+    os.Remove(tmpPath)
+    return err
+  }
+
+  // Sync the directory so the rename itself survives a crash
+  if dirFile, err := os.Open(dir); err == nil {
+    dirFile.Sync()
+    dirFile.Close()
+  }
+  return nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+  "fmt"
+  "time"
+)
+
+// Like records a user liking a chirp. A user likes a chirp at most once.
+type Like struct {
+  Id int `json:"id"`
+  ChirpId int `json:"chirp_id"`
+  UserId int `json:"user_id"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+// original returns the listed chirp that id refers to, following a
+// rechirp to the chirp it reposts
+func (s *DBStructure) original(id int) (Chirp, error) {
+  chirp, ok := s.Chirps[id]
+  if ok && chirp.RechirpOf != 0 {
+    chirp, ok = s.Chirps[chirp.RechirpOf]
+  }
+  if !ok || !chirp.IsListed() {
+    return Chirp{}, fmt.Errorf("No chirp found with id: %v", id)
+  }
+  return chirp, nil
+}
+
+// LikeChirp makes userId like a chirp, or the chirp a rechirp reposts,
+// and returns the liked chirp along with whether the like is new.
+// Liking a chirp twice changes nothing.
+func (db *DB) LikeChirp(chirpId int, userId int) (Chirp, bool, error) {
+  var chirp Chirp
+  changed := false
+  err := db.update("LikeChirp", func(dbStructure *DBStructure) error {
+    original, err := dbStructure.original(chirpId)
+    if err != nil {
+      return err
+    }
+
+    if _, liked := dbStructure.indexes.likesByChirp[original.Id][userId]; !liked {
+      id := dbStructure.nextId("likes")
+      likesTable.put(dbStructure, id, Like{
+        Id: id,
+        ChirpId: original.Id,
+        UserId: userId,
+        CreatedAt: time.Now().UTC(),
+      })
+      changed = true
+    }
+    chirp = original
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, false, err
+  }
+  chirp, err = db.FindChirpById(chirp.Id)
+  return chirp, changed, err
+}
+
+// UnlikeChirp removes userId's like from a chirp, if there is one,
+// and returns the chirp along with whether there was a like to remove
+func (db *DB) UnlikeChirp(chirpId int, userId int) (Chirp, bool, error) {
+  var chirp Chirp
+  changed := false
+  err := db.update("UnlikeChirp", func(dbStructure *DBStructure) error {
+    original, err := dbStructure.original(chirpId)
+    if err != nil {
+      return err
+    }
+
+    if likeId, liked := dbStructure.indexes.likesByChirp[original.Id][userId]; liked {
+      likesTable.remove(dbStructure, likeId)
+      changed = true
+    }
+    chirp = original
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, false, err
+  }
+  chirp, err = db.FindChirpById(chirp.Id)
+  return chirp, changed, err
+}
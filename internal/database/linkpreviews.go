@@ -0,0 +1,91 @@
+package database
+
+import (
+  "fmt"
+  "time"
+  "strings"
+)
+
+// Link preview statuses
+const (
+  LinkPreviewReady = "ready"
+  LinkPreviewFailed = "failed"
+)
+
+// LinkPreview is the cached preview of a page chirps link to. Failed
+// fetches are cached too, so a broken link isn't fetched every time
+// it's chirped.
+type LinkPreview struct {
+  Id int `json:"id"`
+  Url string `json:"url"`
+  Status string `json:"status"`
+  Title string `json:"title,omitempty"`
+  Description string `json:"description,omitempty"`
+  Image string `json:"image,omitempty"`
+  SiteName string `json:"site_name,omitempty"`
+  Error string `json:"error,omitempty"`
+  FetchedAt time.Time `json:"fetched_at"`
+}
+
+// previewKey is the form of a URL previews are cached under. The
+// fragment only picks a place on the page, so it's left out.
+func previewKey(url string) string {
+  if i := strings.IndexByte(url, '#'); i >= 0 {
+    return url[:i]
+  }
+  return url
+}
+
+// previewLinks returns a copy of links with the ready previews filled in
+func (s *DBStructure) previewLinks(links []Link) []Link {
+  previewed := make([]Link, 0, len(links))
+  for _, link := range links {
+    link.Preview = nil
+    if id, ok := s.indexes.linkPreviewsByUrl[previewKey(link.Url)]; ok {
+      if preview := s.LinkPreviews[id]; preview.Status == LinkPreviewReady {
+        link.Preview = &preview
+      }
+    }
+    previewed = append(previewed, link)
+  }
+  return previewed
+}
+
+// FindLinkPreview returns the cached preview of url
+func (db *DB) FindLinkPreview(url string) (LinkPreview, error) {
+  var preview LinkPreview
+  err := db.View(func(dbStructure *DBStructure) error {
+    id, ok := dbStructure.indexes.linkPreviewsByUrl[previewKey(url)]
+    if !ok {
+      return fmt.Errorf("No link preview found for: %v", url)
+    }
+    preview = dbStructure.LinkPreviews[id]
+    return nil
+  })
+  if err != nil {
+    return LinkPreview{}, err
+  }
+  return preview, nil
+}
+
+// SaveLinkPreview caches a preview, replacing any earlier one of the
+// same URL
+func (db *DB) SaveLinkPreview(preview LinkPreview) (LinkPreview, error) {
+  err := db.update("SaveLinkPreview", func(dbStructure *DBStructure) error {
+    if id, ok := dbStructure.indexes.linkPreviewsByUrl[previewKey(preview.Url)]; ok {
+      preview.Id = id
+    } else {
+      preview.Id = dbStructure.nextId("link_previews")
+    }
+    preview.Url = previewKey(preview.Url)
+    if preview.FetchedAt.IsZero() {
+      preview.FetchedAt = time.Now().UTC()
+    }
+    linkPreviewsTable.put(dbStructure, preview.Id, preview)
+    return nil
+  })
+  if err != nil {
+    return LinkPreview{}, err
+  }
+  return preview, nil
+}
@@ -0,0 +1,34 @@
+package database
+
+// memoryStorage persists nothing, so the database lives only as
+// long as the process. It is meant for tests and throwaway environments.
+type memoryStorage struct {}
+
+// NewMemoryDB creates a database that never touches disk
+func NewMemoryDB() (*DB, error) {
+  return newDB("", memoryStorage{}, Options{})
+}
+
+func (s memoryStorage) ensure() error {
+  return nil
+}
+
+func (s memoryStorage) exists() (bool, error) {
+  return true, nil
+}
+
+func (s memoryStorage) load() (DBStructure, error) {
+  return DBStructure{}, nil
+}
+
+func (s memoryStorage) write(dbStructure DBStructure) error {
+  return nil
+}
+
+func (s memoryStorage) writeChanges(changes []journalChange) error {
+  return nil
+}
+
+func (s memoryStorage) close() error {
+  return nil
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+  "fmt"
+  "log"
+  "time"
+  "reflect"
+)
+
+// Migration upgrades a DBStructure from Version-1 to Version.
+// Old data always decodes into the current DBStructure, so a migration
+// fills in or rewrites fields rather than changing the layout.
+type Migration struct {
+  Version int
+  Description string
+  Up func(*DBStructure) error
+}
+
+// migrations is the ordered registry of schema changes. Append new
+// migrations to the end with the next version number; never edit or
+// reorder ones that have shipped.
+var migrations = []Migration{
+  {
+    Version: 1,
+    Description: "Store id sequences with the data",
+    Up: func(s *DBStructure) error {
+      s.initSequences()
+      return nil
+    },
+  },
+  {
+    Version: 2,
+    Description: "Backfill created_at and updated_at on users and chirps with the migration time",
+    Up: func(s *DBStructure) error {
+      now := time.Now().UTC()
+      for id, user := range s.Users {
+        if user.CreatedAt.IsZero() {
+          user.CreatedAt = now
+          user.UpdatedAt = now
+          s.Users[id] = user
+        }
+      }
+      for id, chirp := range s.Chirps {
+        if chirp.CreatedAt.IsZero() {
+          chirp.CreatedAt = now
+          chirp.UpdatedAt = now
+          s.Chirps[id] = chirp
+        }
+      }
+      return nil
+    },
+  },
+  {
+    Version: 3,
+    Description: "Extract mentions and hashtags from existing chirps",
+    Up: func(s *DBStructure) error {
+      if err := s.buildIndexes(); err != nil {
+        return err
+      }
+      for id, chirp := range s.Chirps {
+        chirp.Mentions, chirp.Hashtags = s.extractEntities(chirp.Body)
+        s.Chirps[id] = chirp
+      }
+      return nil
+    },
+  },
+  {
+    Version: 4,
+    Description: "Extract links from existing chirps, dropping mentions and hashtags found inside them",
+    Up: func(s *DBStructure) error {
+      if err := s.buildIndexes(); err != nil {
+        return err
+      }
+      for id, chirp := range s.Chirps {
+        chirp.Mentions, chirp.Hashtags, chirp.Links = s.extractChirpEntities(chirp.Body)
+        s.Chirps[id] = chirp
+      }
+      return nil
+    },
+  },
+}
+
+// SchemaVersion is the version NewDB brings every database up to
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// MigrationReport describes what opening a database did, or would have
+// done in a dry run, to bring it up to SchemaVersion
+type MigrationReport struct {
+  From int
+  To int
+  Applied []Migration
+  Backup string
+  DryRun bool
+}
+
+// backupStorage is implemented by storage backends that can copy
+// their current contents somewhere else
+type backupStorage interface {
+  backup(dest string) error
+}
+
+// pendingMigrations returns the migrations newer than version, in order
+func pendingMigrations(version int) ([]Migration, error) {
+  if version > SchemaVersion {
+    return nil, fmt.Errorf(
+      "Database schema version %v is newer than this build supports (%v)",
+      version, SchemaVersion,
+    )
+  }
+
+  pending := []Migration{}
+  for _, migration := range migrations {
+    if migration.Version > version {
+      pending = append(pending, migration)
+    }
+  }
+  return pending, nil
+}
+
+// applyMigrations runs each migration in turn, stopping at the first failure
+func applyMigrations(dbStructure *DBStructure, pending []Migration) error {
+  for _, migration := range pending {
+    if err := migration.Up(dbStructure); err != nil {
+      return fmt.Errorf(
+        "Migration %v (%v) failed: %v", migration.Version, migration.Description, err,
+      )
+    }
+    dbStructure.SchemaVersion = migration.Version
+  }
+  return nil
+}
+
+// isEmpty reports whether every collection is empty
+func (s DBStructure) isEmpty() bool {
+  v := reflect.ValueOf(s)
+  for i := 0; i < v.NumField(); i++ {
+    field := v.Field(i)
+    if field.Kind() == reflect.Map && field.Len() > 0 && v.Type().Field(i).Name != "Sequences" {
+      return false
+    }
+  }
+  return true
+}
+
+// migrate brings dbStructure up to SchemaVersion. The storage is backed
+// up first and the result written straight back, unless dry run is set,
+// in which case storage is left untouched.
+func (db *DB) migrate(dbStructure DBStructure) (DBStructure, error) {
+  report := MigrationReport{
+    From: dbStructure.SchemaVersion,
+    To: dbStructure.SchemaVersion,
+    Applied: []Migration{},
+    DryRun: db.options.MigrationDryRun,
+  }
+
+  // A database with no data has nothing to upgrade. The version is
+  // still written, since updates only write the records they change.
+  if dbStructure.isEmpty() && dbStructure.SchemaVersion < SchemaVersion {
+    dbStructure.SchemaVersion = SchemaVersion
+    report.To = SchemaVersion
+    if !report.DryRun {
+      if err := db.storage.write(dbStructure); err != nil {
+        return DBStructure{}, err
+      }
+    }
+    db.migrations = report
+    return dbStructure, nil
+  }
+
+  pending, err := pendingMigrations(dbStructure.SchemaVersion)
+  if err != nil {
+    return DBStructure{}, err
+  }
+  if len(pending) == 0 {
+    db.migrations = report
+    return dbStructure, nil
+  }
+
+  if backup, ok := db.storage.(backupStorage); ok && !report.DryRun {
+    report.Backup = fmt.Sprintf(
+      "%s.v%d-%s.bak",
+      db.path, dbStructure.SchemaVersion, time.Now().UTC().Format("20060102150405"),
+    )
+    if err := backup.backup(report.Backup); err != nil {
+      return DBStructure{}, fmt.Errorf("Unable to back up database before migrating: %v", err)
+    }
+  }
+
+  if err := applyMigrations(&dbStructure, pending); err != nil {
+    return DBStructure{}, err
+  }
+  report.To = dbStructure.SchemaVersion
+  report.Applied = pending
+
+  if !report.DryRun {
+    if err := db.storage.write(dbStructure); err != nil {
+      return DBStructure{}, err
+    }
+    log.Printf("Migrated database from version %v to %v", report.From, report.To)
+  }
+
+  db.migrations = report
+  return dbStructure, nil
+}
+
+// Migrations reports what was done to the database when it was opened
+func (db *DB) Migrations() MigrationReport {
+  return db.migrations
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+  "time"
+)
+
+// ModerationMatch is a piece of a chirp body a moderation rule matched
+type ModerationMatch struct {
+  Rule string `json:"rule"`
+  Action string `json:"action"`
+  Text string `json:"text"`
+  Start int `json:"start"`
+  End int `json:"end"`
+}
+
+// ModerationResult records what moderation made of a chirp body that
+// matched at least one rule
+type ModerationResult struct {
+  Id int `json:"id"`
+  // ChirpId is zero when the chirp was rejected and never saved
+  ChirpId int `json:"chirp_id,omitempty"`
+  AuthorId int `json:"author_id"`
+  // Source is the request that submitted the body, create or edit
+  Source string `json:"source"`
+  // Body is the body as submitted, before any masking
+  Body string `json:"body"`
+  Rejected bool `json:"rejected"`
+  Flagged bool `json:"flagged"`
+  Matches []ModerationMatch `json:"matches"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordModerationResult saves a moderation result. A flagged chirp
+// is also reported so it shows up in the moderation queue.
+func (db *DB) RecordModerationResult(result ModerationResult) (ModerationResult, error) {
+  err := db.update("RecordModerationResult", func(dbStructure *DBStructure) error {
+    result.Id = dbStructure.nextId("moderation_results")
+    result.CreatedAt = time.Now().UTC()
+    moderationResultsTable.put(dbStructure, result.Id, result)
+
+    if result.Flagged && result.ChirpId != 0 {
+      dbStructure.addReport(Report{
+        ChirpId: result.ChirpId,
+        Source: ReportSourceModeration,
+        Reason: flagReason(result.Matches),
+      })
+    }
+    return nil
+  })
+  if err != nil {
+    return ModerationResult{}, err
+  }
+  return result, nil
+}
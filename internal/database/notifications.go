@@ -0,0 +1,207 @@
+package database
+
+import (
+  "fmt"
+  "time"
+)
+
+// Notification types
+const (
+  NotificationReply = "reply"
+  NotificationMention = "mention"
+  NotificationLike = "like"
+  NotificationRechirp = "rechirp"
+  NotificationFollow = "follow"
+  NotificationMessage = "message"
+  NotificationModeration = "moderation"
+)
+
+// Notification tells a user that someone did something involving them
+type Notification struct {
+  Id int `json:"id"`
+  UserId int `json:"user_id"`
+  Type string `json:"type"`
+  // ActorId is the user who did it
+  ActorId int `json:"actor_id"`
+  ChirpId int `json:"chirp_id,omitempty"`
+  ConversationId int `json:"conversation_id,omitempty"`
+  // Action and Note are set on moderation notifications
+  Action string `json:"action,omitempty"`
+  Note string `json:"note,omitempty"`
+  CreatedAt time.Time `json:"created_at"`
+  ReadAt *time.Time `json:"read_at"`
+}
+
+// IsRead reports whether the user has read the notification
+func (n Notification) IsRead() bool {
+  return n.ReadAt != nil
+}
+
+// notificationKey holds what a notification tells a user, so two
+// notifications with the same key are about the same event
+type notificationKey struct {
+  userId int
+  notificationType string
+  actorId int
+  chirpId int
+  conversationId int
+  action string
+}
+
+func (n Notification) key() notificationKey {
+  return notificationKey{
+    userId: n.UserId,
+    notificationType: n.Type,
+    actorId: n.ActorId,
+    chirpId: n.ChirpId,
+    conversationId: n.ConversationId,
+    action: n.Action,
+  }
+}
+
+// NotificationQuery selects a page of a user's notifications, newest first
+type NotificationQuery struct {
+  UserId int
+  // Unread leaves out notifications the user has read
+  Unread bool
+  // Limit is the page size. Zero returns everything after the cursor.
+  Limit int
+  // Cursor continues from a NextCursor or PrevCursor of an earlier page
+  Cursor string
+}
+
+// NotificationPage is one page of notifications. UnreadCount counts
+// every unread notification the user can see, not just this page's.
+type NotificationPage struct {
+  Notifications []Notification
+  UnreadCount int
+  NextCursor string
+  PrevCursor string
+}
+
+// notificationVisible reports whether a notification is shown. Ones
+// about a chirp that is deleted or hidden are held back while it is,
+// apart from moderation notices, which are about exactly that.
+func (s *DBStructure) notificationVisible(notification Notification) bool {
+  if notification.ChirpId == 0 || notification.Type == NotificationModeration {
+    return true
+  }
+  chirp, ok := s.Chirps[notification.ChirpId]
+  return ok && s.isListed(chirp)
+}
+
+// Notify stores a notification and reports whether it is new. If the
+// user has an unread notification of the same event it is returned
+// instead, so repeated likes or messages don't pile up.
+func (db *DB) Notify(notification Notification) (Notification, bool, error) {
+  created := false
+  err := db.update("Notify", func(dbStructure *DBStructure) error {
+    created = false
+    if _, ok := dbStructure.Users[notification.UserId]; !ok {
+      return fmt.Errorf("No user found with id: %v", notification.UserId)
+    }
+
+    if id, ok := dbStructure.indexes.unreadNotifications[notification.key()]; ok {
+      notification = dbStructure.Notifications[id]
+      return nil
+    }
+
+    notification.Id = dbStructure.nextId("notifications")
+    notification.CreatedAt = time.Now().UTC()
+    notification.ReadAt = nil
+    notificationsTable.put(dbStructure, notification.Id, notification)
+    created = true
+    return nil
+  })
+  if err != nil {
+    return Notification{}, false, err
+  }
+  return notification, created, nil
+}
+
+// ListNotifications returns a page of a user's notifications
+func (db *DB) ListNotifications(query NotificationQuery) (NotificationPage, error) {
+  var cursor *chirpCursor
+  if query.Cursor != "" {
+    decoded, err := decodeCursor(query.Cursor)
+    if err != nil {
+      return NotificationPage{}, err
+    }
+    cursor = &decoded
+  }
+
+  page := NotificationPage{Notifications: []Notification{}}
+  err := db.View(func(dbStructure *DBStructure) error {
+    ids := dbStructure.indexes.notificationsByUser[query.UserId]
+    for _, id := range ids {
+      notification := dbStructure.Notifications[id]
+      if !notification.IsRead() && dbStructure.notificationVisible(notification) {
+        page.UnreadCount++
+      }
+    }
+
+    ordered := orderedIds{ids: ids, descending: true}
+    pageIds, next, prev := scanPage(ordered, cursor, query.Limit, func(id int) bool {
+      notification := dbStructure.Notifications[id]
+      if query.Unread && notification.IsRead() {
+        return false
+      }
+      return dbStructure.notificationVisible(notification)
+    })
+    for _, id := range pageIds {
+      page.Notifications = append(page.Notifications, dbStructure.Notifications[id])
+    }
+    page.NextCursor, page.PrevCursor = next, prev
+    return nil
+  })
+  if err != nil {
+    return NotificationPage{}, err
+  }
+  return page, nil
+}
+
+// MarkNotificationRead marks one of userId's notifications as read
+func (db *DB) MarkNotificationRead(id int, userId int) (Notification, error) {
+  var notification Notification
+  err := db.update("MarkNotificationRead", func(dbStructure *DBStructure) error {
+    found, ok := dbStructure.Notifications[id]
+    if !ok || found.UserId != userId {
+      return fmt.Errorf("No notification found with id: %v", id)
+    }
+    if !found.IsRead() {
+      readAt := time.Now().UTC()
+      found.ReadAt = &readAt
+      notificationsTable.put(dbStructure, id, found)
+    }
+    notification = found
+    return nil
+  })
+  if err != nil {
+    return Notification{}, err
+  }
+  return notification, nil
+}
+
+// MarkAllNotificationsRead marks every one of userId's notifications as
+// read and returns how many weren't already
+func (db *DB) MarkAllNotificationsRead(userId int) (int, error) {
+  marked := 0
+  err := db.update("MarkAllNotificationsRead", func(dbStructure *DBStructure) error {
+    marked = 0
+    readAt := time.Now().UTC()
+    for _, id := range dbStructure.indexes.notificationsByUser[userId] {
+      notification := dbStructure.Notifications[id]
+      if notification.IsRead() {
+        continue
+      }
+      notification.ReadAt = &readAt
+      notificationsTable.put(dbStructure, id, notification)
+      marked++
+    }
+    return nil
+  })
+  if err != nil {
+    return 0, err
+  }
+  return marked, nil
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+  "testing"
+)
+
+func TestNotifyDedupes(t *testing.T) {
+  db := seedDB(t, 10, Options{})
+  like := Notification{UserId: 1, Type: NotificationLike, ActorId: 2, ChirpId: 1}
+
+  first, created, err := db.Notify(like)
+  if err != nil || !created {
+    t.Fatalf("Got %+v, %v, %v, expected a new notification", first, created, err)
+  }
+  again, created, err := db.Notify(like)
+  if err != nil || created || again.Id != first.Id {
+    t.Errorf("Got %+v, %v, %v, expected notification %v again", again, created, err, first.Id)
+  }
+
+  other := like
+  other.ChirpId = 2
+  if _, created, _ := db.Notify(other); !created {
+    t.Error("A like of another chirp wasn't notified")
+  }
+
+  if _, err := db.MarkNotificationRead(first.Id, 1); err != nil {
+    t.Fatal(err)
+  }
+  afterRead, created, err := db.Notify(like)
+  if err != nil || !created || afterRead.Id == first.Id {
+    t.Errorf("Got %+v, %v, %v, expected a new notification once the first was read", afterRead, created, err)
+  }
+}
@@ -0,0 +1,263 @@
+package database
+
+import (
+  "sort"
+  "errors"
+  "time"
+  "slices"
+  "strings"
+  "encoding/json"
+  "encoding/base64"
+)
+
+// ChirpQuery selects a page of chirps. Chirps are ordered by id, which
+// is also creation order. Zero values leave a filter off.
+type ChirpQuery struct {
+  // AuthorIds limits the page to chirps by any of these authors
+  AuthorIds []int
+  // Since and Until bound CreatedAt. Since is inclusive, Until isn't.
+  Since time.Time
+  Until time.Time
+  // MinId and MaxId bound the id, inclusively
+  MinId int
+  MaxId int
+  // Hashtag limits the page to chirps using a hashtag, given in the
+  // form NormalizeHashtag returns
+  Hashtag string
+  // MentionedUserId limits the page to chirps mentioning a user
+  MentionedUserId int
+  // Contains and Excludes are matched case-insensitively against the
+  // body. Every Contains value has to appear and no Excludes value can.
+  Contains []string
+  Excludes []string
+  Descending bool
+  // Limit is the page size. Zero returns everything after the cursor.
+  Limit int
+  // Cursor continues from a NextCursor or PrevCursor of an earlier page
+  Cursor string
+}
+
+// ChirpPage is one page of chirps. The cursors are empty when there
+// is nothing further in that direction.
+type ChirpPage struct {
+  Chirps []Chirp
+  NextCursor string
+  PrevCursor string
+}
+
+// chirpCursor is the decoded form of a cursor: the id of the chirp at
+// the edge of a page and which side of it the next page is on
+type chirpCursor struct {
+  Id int `json:"id"`
+  Before bool `json:"before,omitempty"`
+}
+
+func encodeCursor(cursor chirpCursor) string {
+  data, _ := json.Marshal(cursor)
+  return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ErrInvalidCursor is returned for a cursor that didn't come from an
+// earlier page, which is the caller's mistake rather than the database's
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+func decodeCursor(value string) (chirpCursor, error) {
+  data, err := base64.RawURLEncoding.DecodeString(value)
+  if err != nil {
+    return chirpCursor{}, ErrInvalidCursor
+  }
+  var cursor chirpCursor
+  if err := json.Unmarshal(data, &cursor); err != nil {
+    return chirpCursor{}, ErrInvalidCursor
+  }
+  return cursor, nil
+}
+
+// orderedIds is a sorted id list read in either direction without
+// copying or re-sorting it
+type orderedIds struct {
+  ids []int
+  descending bool
+}
+
+func (o orderedIds) len() int {
+  return len(o.ids)
+}
+
+func (o orderedIds) at(i int) int {
+  if o.descending {
+    return o.ids[len(o.ids)-1-i]
+  }
+  return o.ids[i]
+}
+
+// countBefore returns how many ids come before id in this order
+func (o orderedIds) countBefore(id int) int {
+  if o.descending {
+    return len(o.ids) - sort.SearchInts(o.ids, id+1)
+  }
+  return sort.SearchInts(o.ids, id)
+}
+
+// countThrough returns how many ids come before id, or are id
+func (o orderedIds) countThrough(id int) int {
+  if o.descending {
+    return len(o.ids) - sort.SearchInts(o.ids, id)
+  }
+  return sort.SearchInts(o.ids, id+1)
+}
+
+// matches reports whether a chirp passes the filters that candidateIds
+// didn't already apply
+func (query ChirpQuery) matches(chirp Chirp) bool {
+  if query.Hashtag != "" && !slices.Contains(uniqueHashtags(chirp.Hashtags), query.Hashtag) {
+    return false
+  }
+  if query.MentionedUserId != 0 && !slices.Contains(uniqueMentions(chirp.Mentions), query.MentionedUserId) {
+    return false
+  }
+  if len(query.AuthorIds) > 0 && !slices.Contains(query.AuthorIds, chirp.AuthorId) {
+    return false
+  }
+  if !query.Since.IsZero() && chirp.CreatedAt.Before(query.Since) {
+    return false
+  }
+  if !query.Until.IsZero() && !chirp.CreatedAt.Before(query.Until) {
+    return false
+  }
+  if len(query.Contains) == 0 && len(query.Excludes) == 0 {
+    return true
+  }
+  body := strings.ToLower(chirp.Body)
+  for _, value := range query.Contains {
+    if !strings.Contains(body, strings.ToLower(value)) {
+      return false
+    }
+  }
+  for _, value := range query.Excludes {
+    if strings.Contains(body, strings.ToLower(value)) {
+      return false
+    }
+  }
+  return true
+}
+
+// candidateIds returns the sorted ids of the chirps the most selective
+// index narrows the query to, within the id bounds
+func (dbStructure *DBStructure) candidateIds(query ChirpQuery) []int {
+  ids := dbStructure.indexes.chirpIds
+  if query.Hashtag != "" {
+    ids = dbStructure.indexes.chirpsByHashtag[query.Hashtag]
+  } else if query.MentionedUserId != 0 {
+    ids = dbStructure.indexes.chirpsByMention[query.MentionedUserId]
+  } else if len(query.AuthorIds) == 1 {
+    ids = dbStructure.indexes.chirpsByAuthor[query.AuthorIds[0]]
+  } else if len(query.AuthorIds) > 1 {
+    seen := map[int]bool{}
+    ids = []int{}
+    for _, authorId := range query.AuthorIds {
+      if seen[authorId] {
+        continue
+      }
+      seen[authorId] = true
+      ids = append(ids, dbStructure.indexes.chirpsByAuthor[authorId]...)
+    }
+    sort.Ints(ids)
+  }
+
+  if query.MinId != 0 {
+    ids = ids[sort.SearchInts(ids, query.MinId):]
+  }
+  if query.MaxId != 0 {
+    ids = ids[:sort.SearchInts(ids, query.MaxId+1)]
+  }
+  return ids
+}
+
+// ListChirps returns a page of listed chirps. Pages are
+// read from the sorted id indexes, so without time or body filters the
+// cost depends on the page size rather than the number of chirps.
+func (db *DB) ListChirps(query ChirpQuery) (ChirpPage, error) {
+  var cursor *chirpCursor
+  if query.Cursor != "" {
+    decoded, err := decodeCursor(query.Cursor)
+    if err != nil {
+      return ChirpPage{}, err
+    }
+    cursor = &decoded
+  }
+
+  page := ChirpPage{Chirps: []Chirp{}}
+  err := db.View(func(dbStructure *DBStructure) error {
+    ordered := orderedIds{ids: dbStructure.candidateIds(query), descending: query.Descending}
+    ids, next, prev := scanPage(ordered, cursor, query.Limit, func(id int) bool {
+      return query.matches(dbStructure.Chirps[id])
+    })
+    for _, id := range ids {
+      page.Chirps = append(page.Chirps, dbStructure.expand(dbStructure.Chirps[id]))
+    }
+    page.NextCursor, page.PrevCursor = next, prev
+    return nil
+  })
+  if err != nil {
+    return ChirpPage{}, err
+  }
+  return page, nil
+}
+
+// scanPage walks ordered from cursor, collecting up to limit ids that
+// match accepts, and returns them in order with the cursors of the
+// pages either side. A zero limit collects everything after the cursor.
+func scanPage(ordered orderedIds, cursor *chirpCursor, limit int, match func(id int) bool) ([]int, string, string) {
+  n := ordered.len()
+  matchAt := func(i int) bool {
+    return match(ordered.at(i))
+  }
+  full := func(positions []int) bool {
+    return limit > 0 && len(positions) >= limit
+  }
+
+  positions := []int{}
+  if cursor != nil && cursor.Before {
+    for i := ordered.countBefore(cursor.Id) - 1; i >= 0 && !full(positions); i-- {
+      if matchAt(i) {
+        positions = append(positions, i)
+      }
+    }
+    slices.Reverse(positions)
+  } else {
+    start := 0
+    if cursor != nil {
+      start = ordered.countThrough(cursor.Id)
+    }
+    for i := start; i < n && !full(positions); i++ {
+      if matchAt(i) {
+        positions = append(positions, i)
+      }
+    }
+  }
+  if len(positions) == 0 {
+    return []int{}, "", ""
+  }
+
+  ids := make([]int, 0, len(positions))
+  for _, i := range positions {
+    ids = append(ids, ordered.at(i))
+  }
+
+  var next, prev string
+  first, last := positions[0], positions[len(positions)-1]
+  for i := last + 1; i < n; i++ {
+    if matchAt(i) {
+      next = encodeCursor(chirpCursor{Id: ordered.at(last)})
+      break
+    }
+  }
+  for i := first - 1; i >= 0; i-- {
+    if matchAt(i) {
+      prev = encodeCursor(chirpCursor{Id: ordered.at(first), Before: true})
+      break
+    }
+  }
+  return ids, next, prev
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+  "time"
+)
+
+// isListed reports whether a chirp should appear in feeds. A rechirp
+// is only listed while the chirp it reposts is.
+func (s *DBStructure) isListed(chirp Chirp) bool {
+  if !chirp.IsListed() {
+    return false
+  }
+  if chirp.RechirpOf == 0 {
+    return true
+  }
+  original, ok := s.Chirps[chirp.RechirpOf]
+  return ok && original.IsListed()
+}
+
+// Rechirp reposts a chirp as userId. The rechirp is a chirp of its own
+// with no body, so it shows up in feeds as the reposter's. Rechirping
+// a rechirp reposts the original, and rechirping a chirp twice returns
+// the first rechirp. If the user deleted that rechirp it's undeleted,
+// but one a moderator took down stays down. created reports whether
+// the rechirp is new or was undeleted.
+func (db *DB) Rechirp(chirpId int, userId int) (Chirp, bool, error) {
+  var rechirp Chirp
+  created := false
+  err := db.update("Rechirp", func(dbStructure *DBStructure) error {
+    original, err := dbStructure.original(chirpId)
+    if err != nil {
+      return err
+    }
+
+    if id, ok := dbStructure.indexes.rechirpsByUser[original.Id][userId]; ok {
+      rechirp = dbStructure.Chirps[id]
+      if rechirp.IsDeleted() && rechirp.DeletedBy == userId && !rechirp.IsHidden() {
+        rechirp.DeletedAt = nil
+        rechirp.DeletedBy = 0
+        rechirp.UpdatedAt = time.Now().UTC()
+        chirpsTable.put(dbStructure, id, rechirp)
+        created = true
+      }
+      return nil
+    }
+
+    id := dbStructure.nextId("chirps")
+    if db.options.TimeOrderedChirpIds {
+      id = dbStructure.nextTimeOrderedId("chirps", time.Now())
+    }
+    now := time.Now().UTC()
+    rechirp = Chirp{
+      Id: id,
+      AuthorId: userId,
+      RechirpOf: original.Id,
+      CreatedAt: now,
+      UpdatedAt: now,
+    }
+    chirpsTable.put(dbStructure, id, rechirp)
+    created = true
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, false, err
+  }
+  rechirp, err = db.FindChirpById(rechirp.Id)
+  return rechirp, created, err
+}
+
+// Unrechirp removes userId's rechirp of a chirp, if there is one, and
+// returns the chirp along with whether there was a rechirp to remove.
+// Rechirps have nothing worth keeping, so unlike deleted chirps they
+// aren't tombstoned.
+func (db *DB) Unrechirp(chirpId int, userId int) (Chirp, bool, error) {
+  var chirp Chirp
+  removed := false
+  err := db.update("Unrechirp", func(dbStructure *DBStructure) error {
+    original, err := dbStructure.original(chirpId)
+    if err != nil {
+      return err
+    }
+
+    if id, ok := dbStructure.indexes.rechirpsByChirp[original.Id][userId]; ok {
+      chirpsTable.remove(dbStructure, id)
+      removed = true
+    }
+    chirp = original
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, false, err
+  }
+  chirp, err = db.FindChirpById(chirp.Id)
+  return chirp, removed, err
+}
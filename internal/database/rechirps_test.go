@@ -0,0 +1,55 @@
+package database
+
+import (
+  "testing"
+)
+
+func TestRechirpAfterDeletingIt(t *testing.T) {
+  db := seedDB(t, 10, Options{})
+
+  rechirp, created, err := db.Rechirp(1, 5)
+  if err != nil || !created {
+    t.Fatalf("Got %+v, %v, %v, expected a new rechirp", rechirp, created, err)
+  }
+  if err := db.DeleteChirp(rechirp.Id, 5); err != nil {
+    t.Fatal(err)
+  }
+
+  again, created, err := db.Rechirp(1, 5)
+  if err != nil || !created {
+    t.Fatalf("Got %+v, %v, %v, expected the rechirp back", again, created, err)
+  }
+  if again.Id != rechirp.Id || again.IsDeleted() {
+    t.Errorf("Got %+v, expected rechirp %v undeleted", again, rechirp.Id)
+  }
+  if _, created, _ := db.Rechirp(1, 5); created {
+    t.Error("Rechirping a third time created a rechirp")
+  }
+
+  db.View(func(dbStructure *DBStructure) error {
+    if ids := dbStructure.indexes.allRechirpsByChirp[1]; len(ids) != 1 {
+      t.Errorf("Chirp 1 has rechirps %v, expected just %v", ids, rechirp.Id)
+    }
+    return nil
+  })
+}
+
+func TestRechirpAfterModeratorDeletesIt(t *testing.T) {
+  db := seedDB(t, 10, Options{})
+
+  rechirp, _, err := db.Rechirp(1, 5)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, err := db.ModerateChirp(rechirp.Id, 99, ModerationDelete, ""); err != nil {
+    t.Fatal(err)
+  }
+
+  again, created, err := db.Rechirp(1, 5)
+  if err != nil || created {
+    t.Fatalf("Got %+v, %v, %v, expected nothing to change", again, created, err)
+  }
+  if again.Id != rechirp.Id || !again.IsDeleted() {
+    t.Errorf("Got %+v, expected rechirp %v to stay deleted", again, rechirp.Id)
+  }
+}
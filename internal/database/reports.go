@@ -0,0 +1,230 @@
+package database
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "time"
+)
+
+const (
+  // ReportSourceUser is a report filed by a user
+  ReportSourceUser = "user"
+  // ReportSourceModeration is a report filed when a moderation rule
+  // flagged a chirp for review
+  ReportSourceModeration = "moderation"
+)
+
+// Actions an admin can take on a reported chirp
+const (
+  ModerationHide = "hide"
+  ModerationDelete = "delete"
+  ModerationDismiss = "dismiss"
+  ModerationWarn = "warn"
+)
+
+// Report asks admins to review a chirp. It stays open until an admin
+// acts on the chirp.
+type Report struct {
+  Id int `json:"id"`
+  ChirpId int `json:"chirp_id"`
+  // ReporterId is zero for reports filed by moderation rules
+  ReporterId int `json:"reporter_id,omitempty"`
+  Source string `json:"source"`
+  Reason string `json:"reason"`
+  CreatedAt time.Time `json:"created_at"`
+  ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+  ResolvedBy int `json:"resolved_by,omitempty"`
+  // Resolution is the action that closed the report
+  Resolution string `json:"resolution,omitempty"`
+}
+
+// IsOpen reports whether the report is still waiting for review
+func (r Report) IsOpen() bool {
+  return r.ResolvedAt == nil
+}
+
+// ModerationLogEntry records an admin acting on a chirp
+type ModerationLogEntry struct {
+  Id int `json:"id"`
+  ChirpId int `json:"chirp_id"`
+  // AuthorId is the author of the chirp, who a warning is for
+  AuthorId int `json:"author_id"`
+  ModeratorId int `json:"moderator_id"`
+  Action string `json:"action"`
+  Note string `json:"note,omitempty"`
+  // ReportIds are the reports the action resolved
+  ReportIds []int `json:"report_ids"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+// ModerationQueueItem is a chirp waiting for review with its open reports
+type ModerationQueueItem struct {
+  Chirp Chirp `json:"chirp"`
+  Reports []Report `json:"reports"`
+}
+
+// IsHidden reports whether an admin has hidden the chirp
+func (c Chirp) IsHidden() bool {
+  return c.HiddenAt != nil
+}
+
+// IsListed reports whether the chirp should appear in feeds and
+// searches, which it does unless it's deleted or hidden
+func (c Chirp) IsListed() bool {
+  return !c.IsDeleted() && !c.IsHidden()
+}
+
+// addReport files a report against a chirp
+func (s *DBStructure) addReport(report Report) Report {
+  report.Id = s.nextId("reports")
+  report.CreatedAt = time.Now().UTC()
+  reportsTable.put(s, report.Id, report)
+  return report
+}
+
+// ReportChirp files a user's report against a chirp. Reporting a chirp
+// again while the first report is open returns the first report.
+func (db *DB) ReportChirp(chirpId int, reporterId int, reason string) (Report, error) {
+  var report Report
+  err := db.update("ReportChirp", func(dbStructure *DBStructure) error {
+    chirp, ok := dbStructure.Chirps[chirpId]
+    if !ok || !chirp.IsListed() {
+      return fmt.Errorf("No chirp found with id: %v", chirpId)
+    }
+
+    for _, reportId := range dbStructure.indexes.openReportsByChirp[chirpId] {
+      if existing := dbStructure.Reports[reportId]; existing.ReporterId == reporterId {
+        report = existing
+        return nil
+      }
+    }
+
+    report = dbStructure.addReport(Report{
+      ChirpId: chirpId,
+      ReporterId: reporterId,
+      Source: ReportSourceUser,
+      Reason: reason,
+    })
+    return nil
+  })
+  if err != nil {
+    return Report{}, err
+  }
+  return report, nil
+}
+
+// ModerationQueue returns the chirps with open reports, the one waiting
+// longest first
+func (db *DB) ModerationQueue() ([]ModerationQueueItem, error) {
+  queue := []ModerationQueueItem{}
+  err := db.View(func(dbStructure *DBStructure) error {
+    for chirpId, reportIds := range dbStructure.indexes.openReportsByChirp {
+      item := ModerationQueueItem{Chirp: dbStructure.expand(dbStructure.Chirps[chirpId]), Reports: []Report{}}
+      for _, reportId := range reportIds {
+        item.Reports = append(item.Reports, dbStructure.Reports[reportId])
+      }
+      queue = append(queue, item)
+    }
+    return nil
+  })
+  if err != nil {
+    return []ModerationQueueItem{}, err
+  }
+  // Report ids go up over time, so the lowest is the oldest
+  sort.Slice(queue, func(i, j int) bool {
+    return queue[i].Reports[0].Id < queue[j].Reports[0].Id
+  })
+  return queue, nil
+}
+
+// ModerateChirp applies an admin's action to a chirp, resolves the
+// chirp's open reports and records the action in the moderation log
+func (db *DB) ModerateChirp(chirpId int, moderatorId int, action string, note string) (ModerationLogEntry, error) {
+  var entry ModerationLogEntry
+  err := db.update("ModerateChirp", func(dbStructure *DBStructure) error {
+    chirp, ok := dbStructure.Chirps[chirpId]
+    if !ok {
+      return fmt.Errorf("No chirp found with id: %v", chirpId)
+    }
+
+    now := time.Now().UTC()
+    switch action {
+    case ModerationHide:
+      if !chirp.IsHidden() {
+        chirp.HiddenAt = &now
+        chirp.HiddenBy = moderatorId
+      }
+    case ModerationDelete:
+      if !chirp.IsDeleted() {
+        chirp.DeletedAt = &now
+        chirp.DeletedBy = moderatorId
+      }
+    case ModerationDismiss, ModerationWarn:
+    default:
+      return fmt.Errorf("Unknown moderation action: %v", action)
+    }
+    chirpsTable.put(dbStructure, chirpId, chirp)
+
+    reportIds := []int{}
+    for _, reportId := range dbStructure.indexes.openReportsByChirp[chirpId] {
+      report := dbStructure.Reports[reportId]
+      report.ResolvedAt = &now
+      report.ResolvedBy = moderatorId
+      report.Resolution = action
+      reportsTable.put(dbStructure, reportId, report)
+      reportIds = append(reportIds, reportId)
+    }
+
+    entry = ModerationLogEntry{
+      Id: dbStructure.nextId("moderation_log"),
+      ChirpId: chirpId,
+      AuthorId: chirp.AuthorId,
+      ModeratorId: moderatorId,
+      Action: action,
+      Note: note,
+      ReportIds: reportIds,
+      CreatedAt: now,
+    }
+    moderationLogTable.put(dbStructure, entry.Id, entry)
+    return nil
+  })
+  if err != nil {
+    return ModerationLogEntry{}, err
+  }
+  return entry, nil
+}
+
+// ListModerationLog returns moderation log entries oldest first,
+// only those for one chirp if chirpId isn't zero
+func (db *DB) ListModerationLog(chirpId int) ([]ModerationLogEntry, error) {
+  entries := []ModerationLogEntry{}
+  err := db.View(func(dbStructure *DBStructure) error {
+    for _, entry := range dbStructure.ModerationLog {
+      if chirpId == 0 || entry.ChirpId == chirpId {
+        entries = append(entries, entry)
+      }
+    }
+    return nil
+  })
+  if err != nil {
+    return []ModerationLogEntry{}, err
+  }
+  sort.Slice(entries, func(i, j int) bool {
+    return entries[i].Id < entries[j].Id
+  })
+  return entries, nil
+}
+
+// flagReason describes the rules that flagged a chirp for review
+func flagReason(matches []ModerationMatch) string {
+  rules := []string{}
+  seen := map[string]bool{}
+  for _, match := range matches {
+    if match.Action == "flag" && !seen[match.Rule] {
+      seen[match.Rule] = true
+      rules = append(rules, match.Rule)
+    }
+  }
+  return "Flagged by " + strings.Join(rules, ", ")
+}
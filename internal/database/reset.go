@@ -0,0 +1,7 @@
+package database
+
+// Reset removes every record and restarts the id sequences, leaving
+// an empty database at the current schema version
+func (db *DB) Reset() error {
+  return db.replace("Reset", DBStructure{SchemaVersion: SchemaVersion})
+}
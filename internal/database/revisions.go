@@ -0,0 +1,67 @@
+package database
+
+import (
+  "fmt"
+  "time"
+)
+
+// ChirpRevision is a body a chirp had before it was edited
+type ChirpRevision struct {
+  Id int `json:"id"`
+  ChirpId int `json:"chirp_id"`
+  Body string `json:"body"`
+  // CreatedAt is when this body was written
+  CreatedAt time.Time `json:"created_at"`
+  // ReplacedAt is when an edit replaced it
+  ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// EditChirp replaces a chirp's body, keeping the old one as a revision
+func (db *DB) EditChirp(id int, body string) (Chirp, error) {
+  var chirp Chirp
+  err := db.update("EditChirp", func(dbStructure *DBStructure) error {
+    foundChirp, ok := dbStructure.Chirps[id]
+    if !ok || foundChirp.IsDeleted() {
+      return fmt.Errorf("No chirp found with id: %v", id)
+    }
+    if foundChirp.RechirpOf != 0 {
+      return fmt.Errorf("Rechirps can't be edited")
+    }
+
+    now := time.Now().UTC()
+    revisionId := dbStructure.nextId("revisions")
+    revisionsTable.put(dbStructure, revisionId, ChirpRevision{
+      Id: revisionId,
+      ChirpId: id,
+      Body: foundChirp.Body,
+      CreatedAt: foundChirp.UpdatedAt,
+      ReplacedAt: now,
+    })
+
+    foundChirp.Body = body
+    foundChirp.Mentions, foundChirp.Hashtags, foundChirp.Links = dbStructure.extractChirpEntities(body)
+    foundChirp.UpdatedAt = now
+    chirpsTable.put(dbStructure, id, foundChirp)
+    chirp = dbStructure.expand(foundChirp)
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, err
+  }
+  return chirp, nil
+}
+
+// ListChirpRevisions returns a chirp's earlier bodies, oldest first
+func (db *DB) ListChirpRevisions(id int) ([]ChirpRevision, error) {
+  revisions := []ChirpRevision{}
+  err := db.View(func(dbStructure *DBStructure) error {
+    for _, revisionId := range dbStructure.indexes.revisionsByChirp[id] {
+      revisions = append(revisions, dbStructure.Revisions[revisionId])
+    }
+    return nil
+  })
+  if err != nil {
+    return []ChirpRevision{}, err
+  }
+  return revisions, nil
+}
@@ -0,0 +1,300 @@
+package database
+
+import (
+  "math"
+  "errors"
+  "sort"
+  "strings"
+  "unicode"
+  "encoding/json"
+  "encoding/base64"
+)
+
+// searchIndex is an inverted index over the bodies of listed chirps.
+// It lives on the DB rather than with the other indexes and is only
+// touched under the write lock.
+type searchIndex struct {
+  // postings maps a term to the positions it appears at in each chirp
+  postings map[string]map[int][]int
+  // docs keeps each chirp's tokens so it can be removed again
+  docs map[int][]string
+  // terms is every term in postings, sorted, for prefix queries
+  terms []string
+}
+
+func newSearchIndex() *searchIndex {
+  return &searchIndex{
+    postings: map[string]map[int][]int{},
+    docs: map[int][]string{},
+    terms: []string{},
+  }
+}
+
+// tokenize lowercases text and splits it into runs of letters and digits
+func tokenize(text string) []string {
+  return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+    return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+  })
+}
+
+func (idx *searchIndex) add(id int, body string) {
+  tokens := tokenize(body)
+  idx.docs[id] = tokens
+  for position, token := range tokens {
+    postings, ok := idx.postings[token]
+    if !ok {
+      postings = map[int][]int{}
+      idx.postings[token] = postings
+      i := sort.SearchStrings(idx.terms, token)
+      idx.terms = append(idx.terms, "")
+      copy(idx.terms[i+1:], idx.terms[i:])
+      idx.terms[i] = token
+    }
+    postings[id] = append(postings[id], position)
+  }
+}
+
+func (idx *searchIndex) remove(id int) {
+  for _, token := range idx.docs[id] {
+    postings := idx.postings[token]
+    delete(postings, id)
+    if len(postings) == 0 {
+      delete(idx.postings, token)
+      i := sort.SearchStrings(idx.terms, token)
+      if i < len(idx.terms) && idx.terms[i] == token {
+        idx.terms = append(idx.terms[:i], idx.terms[i+1:]...)
+      }
+    }
+  }
+  delete(idx.docs, id)
+}
+
+// buildSearchIndex indexes every listed chirp
+func buildSearchIndex(chirps map[int]Chirp) *searchIndex {
+  idx := newSearchIndex()
+  for id, chirp := range chirps {
+    if chirp.IsListed() {
+      idx.add(id, chirp.Body)
+    }
+  }
+  return idx
+}
+
+// apply reindexes the chirps an update changed
+func (idx *searchIndex) apply(tx *tx) {
+  for _, c := range tx.order {
+    if c.table != chirpsTable.name {
+      continue
+    }
+    old, chirp := c.before.(Chirp), c.after.(Chirp)
+    if c.existed == c.exists && old.Body == chirp.Body && old.IsListed() == chirp.IsListed() {
+      continue
+    }
+    if c.existed && old.IsListed() {
+      idx.remove(c.id)
+    }
+    if c.exists && chirp.IsListed() {
+      idx.add(c.id, chirp.Body)
+    }
+  }
+}
+
+// searchClause is one part of a query: a single term, a prefix
+// written as term*, or a "quoted phrase"
+type searchClause struct {
+  terms []string
+  prefix bool
+}
+
+// parseSearchQuery splits a query into clauses. Every clause has to
+// match for a chirp to be returned.
+func parseSearchQuery(text string) []searchClause {
+  clauses := []searchClause{}
+  parts := strings.Split(text, `"`)
+  for i, part := range parts {
+    // Odd parts were inside quotes
+    if i%2 == 1 {
+      if terms := tokenize(part); len(terms) > 0 {
+        clauses = append(clauses, searchClause{terms: terms})
+      }
+      continue
+    }
+    for _, word := range strings.Fields(part) {
+      prefix := strings.HasSuffix(word, "*")
+      for _, term := range tokenize(word) {
+        clauses = append(clauses, searchClause{terms: []string{term}})
+      }
+      if prefix && len(clauses) > 0 {
+        clauses[len(clauses)-1].prefix = true
+      }
+    }
+  }
+  return clauses
+}
+
+// idf weighs rare terms above common ones
+func (idx *searchIndex) idf(term string) float64 {
+  return math.Log(1 + float64(len(idx.docs))/float64(1+len(idx.postings[term])))
+}
+
+// match scores every chirp a clause matches
+func (idx *searchIndex) match(clause searchClause) map[int]float64 {
+  scores := map[int]float64{}
+
+  if clause.prefix {
+    prefix := clause.terms[0]
+    for i := sort.SearchStrings(idx.terms, prefix); i < len(idx.terms) && strings.HasPrefix(idx.terms[i], prefix); i++ {
+      term := idx.terms[i]
+      idf := idx.idf(term)
+      for id, positions := range idx.postings[term] {
+        scores[id] += float64(len(positions)) * idf
+      }
+    }
+    return scores
+  }
+
+  first := clause.terms[0]
+  weight := 0.0
+  for _, term := range clause.terms {
+    weight += idx.idf(term)
+  }
+
+  for id, positions := range idx.postings[first] {
+    count := 0
+    for _, start := range positions {
+      if idx.phraseAt(id, clause.terms, start) {
+        count++
+      }
+    }
+    if count > 0 {
+      scores[id] = float64(count) * weight
+    }
+  }
+  return scores
+}
+
+// phraseAt reports whether terms appear in order from position start
+func (idx *searchIndex) phraseAt(id int, terms []string, start int) bool {
+  tokens := idx.docs[id]
+  if start+len(terms) > len(tokens) {
+    return false
+  }
+  for i, term := range terms {
+    if tokens[start+i] != term {
+      return false
+    }
+  }
+  return true
+}
+
+// SearchQuery finds chirps whose body matches Text. Terms match whole
+// words case-insensitively, term* matches any word starting with term
+// and "quoted words" must appear together in that order.
+type SearchQuery struct {
+  Text string
+  Limit int
+  Cursor string
+}
+
+// SearchResult is a matching chirp and how well it matched
+type SearchResult struct {
+  Chirp Chirp `json:"chirp"`
+  Score float64 `json:"score"`
+}
+
+// SearchPage is one page of results, best match first
+type SearchPage struct {
+  Results []SearchResult
+  NextCursor string
+  PrevCursor string
+}
+
+func encodeOffset(offset int) string {
+  data, _ := json.Marshal(map[string]int{"offset": offset})
+  return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOffset(value string) (int, error) {
+  data, err := base64.RawURLEncoding.DecodeString(value)
+  if err != nil {
+    return 0, ErrInvalidCursor
+  }
+  cursor := map[string]int{}
+  if err := json.Unmarshal(data, &cursor); err != nil || cursor["offset"] < 0 {
+    return 0, ErrInvalidCursor
+  }
+  return cursor["offset"], nil
+}
+
+// ErrNoSearchTerms is returned for a search with nothing to look for
+var ErrNoSearchTerms = errors.New("Search query has no words in it")
+
+// SearchChirps runs a full text search over listed chirps
+func (db *DB) SearchChirps(query SearchQuery) (SearchPage, error) {
+  clauses := parseSearchQuery(query.Text)
+  if len(clauses) == 0 {
+    return SearchPage{}, ErrNoSearchTerms
+  }
+
+  offset := 0
+  if query.Cursor != "" {
+    decoded, err := decodeOffset(query.Cursor)
+    if err != nil {
+      return SearchPage{}, err
+    }
+    offset = decoded
+  }
+
+  db.mux.RLock()
+  defer db.mux.RUnlock()
+
+  scores := db.search.match(clauses[0])
+  for _, clause := range clauses[1:] {
+    clauseScores := db.search.match(clause)
+    for id := range scores {
+      if score, ok := clauseScores[id]; ok {
+        scores[id] += score
+      } else {
+        delete(scores, id)
+      }
+    }
+  }
+
+  // Rank by id and score alone so only the chirps on this page
+  // need expanding
+  ranked := make([]int, 0, len(scores))
+  for id := range scores {
+    ranked = append(ranked, id)
+  }
+  // Best match first, newest first between equal matches
+  sort.Slice(ranked, func(i, j int) bool {
+    if scores[ranked[i]] != scores[ranked[j]] {
+      return scores[ranked[i]] > scores[ranked[j]]
+    }
+    return ranked[i] > ranked[j]
+  })
+
+  page := SearchPage{Results: []SearchResult{}}
+  if offset >= len(ranked) {
+    return page, nil
+  }
+  end := len(ranked)
+  if query.Limit > 0 && offset+query.Limit < end {
+    end = offset + query.Limit
+  }
+  for _, id := range ranked[offset:end] {
+    page.Results = append(page.Results, SearchResult{Chirp: db.cache.expand(db.cache.Chirps[id]), Score: scores[id]})
+  }
+
+  if end < len(ranked) {
+    page.NextCursor = encodeOffset(end)
+  }
+  if offset > 0 {
+    previous := 0
+    if query.Limit > 0 && offset-query.Limit > 0 {
+      previous = offset - query.Limit
+    }
+    page.PrevCursor = encodeOffset(previous)
+  }
+  return page, nil
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+  "fmt"
+  "os"
+  "io"
+  "sort"
+  "time"
+  "strings"
+  "path/filepath"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+)
+
+// Snapshot is a point in time copy of the database written as JSON,
+// with a sha256 checksum kept next to it
+type Snapshot struct {
+  Name string `json:"name"`
+  Path string `json:"path"`
+  CreatedAt time.Time `json:"created_at"`
+  Checksum string `json:"checksum"`
+  Size int64 `json:"size"`
+}
+
+// SnapshotRetention decides which snapshots PruneSnapshots keeps.
+// The newest snapshot is always kept.
+type SnapshotRetention struct {
+  // Keep is how many snapshots to keep. Zero keeps any number.
+  Keep int
+  // MaxAge removes snapshots older than this. Zero keeps any age.
+  MaxAge time.Duration
+}
+
+const (
+  snapshotPrefix = "snapshot-"
+  snapshotSuffix = ".json"
+  checksumSuffix = ".sha256"
+  snapshotTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Snapshot writes the current state of the database to a new file in
+// dir. The snapshot is taken from memory, so it works the same for
+// every backend and includes changes that haven't been flushed yet.
+func (db *DB) Snapshot(dir string) (Snapshot, error) {
+  // Updates change the cache in place, so it has to be encoded before
+  // the lock is let go
+  db.mux.RLock()
+  data, err := json.Marshal(db.cache)
+  db.mux.RUnlock()
+  if err != nil {
+    return Snapshot{}, err
+  }
+
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return Snapshot{}, err
+  }
+
+  createdAt := time.Now().UTC()
+  name := snapshotPrefix + createdAt.Format(snapshotTimeFormat) + snapshotSuffix
+  path := filepath.Join(dir, name)
+  sum := sha256.Sum256(data)
+  checksum := hex.EncodeToString(sum[:])
+
+  if err := writeFileAtomic(path, data); err != nil {
+    return Snapshot{}, err
+  }
+  // Same format as sha256sum, so snapshots can be checked by hand
+  checksumLine := fmt.Sprintf("%s  %s\n", checksum, name)
+  if err := writeFileAtomic(path+checksumSuffix, []byte(checksumLine)); err != nil {
+    os.Remove(path)
+    return Snapshot{}, err
+  }
+
+  return Snapshot{
+    Name: name,
+    Path: path,
+    CreatedAt: createdAt,
+    Checksum: checksum,
+    Size: int64(len(data)),
+  }, nil
+}
+
+// ListSnapshots returns the snapshots in dir, newest first
+func ListSnapshots(dir string) ([]Snapshot, error) {
+  entries, err := os.ReadDir(dir)
+  if os.IsNotExist(err) {
+    return []Snapshot{}, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  snapshots := []Snapshot{}
+  for _, entry := range entries {
+    name := entry.Name()
+    if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+      continue
+    }
+
+    stamp := strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix)
+    createdAt, err := time.Parse(snapshotTimeFormat, stamp)
+    if err != nil {
+      continue
+    }
+
+    path := filepath.Join(dir, name)
+    checksum, err := readChecksum(path)
+    if err != nil {
+      return nil, err
+    }
+    info, err := entry.Info()
+    if err != nil {
+      return nil, err
+    }
+
+    snapshots = append(snapshots, Snapshot{
+      Name: name,
+      Path: path,
+      CreatedAt: createdAt,
+      Checksum: checksum,
+      Size: info.Size(),
+    })
+  }
+
+  sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+  return snapshots, nil
+}
+
+// PruneSnapshots deletes the snapshots in dir that fall outside the
+// retention policy and returns the ones it deleted
+func PruneSnapshots(dir string, retention SnapshotRetention) ([]Snapshot, error) {
+  snapshots, err := ListSnapshots(dir)
+  if err != nil {
+    return nil, err
+  }
+
+  pruned := []Snapshot{}
+  now := time.Now()
+  for i, snapshot := range snapshots {
+    if i == 0 {
+      continue
+    }
+    tooMany := retention.Keep > 0 && i >= retention.Keep
+    tooOld := retention.MaxAge > 0 && now.Sub(snapshot.CreatedAt) > retention.MaxAge
+    if !tooMany && !tooOld {
+      continue
+    }
+
+    if err := os.Remove(snapshot.Path); err != nil {
+      return pruned, err
+    }
+    if err := os.Remove(snapshot.Path + checksumSuffix); err != nil && !os.IsNotExist(err) {
+      return pruned, err
+    }
+    pruned = append(pruned, snapshot)
+  }
+  return pruned, nil
+}
+
+// Restore replaces the contents of the database with a snapshot after
+// checking its checksum. Snapshots from older schema versions are
+// migrated on the way in.
+func (db *DB) Restore(path string) error {
+  file, err := os.Open(path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  hash := sha256.New()
+  data, err := io.ReadAll(io.TeeReader(file, hash))
+  if err != nil {
+    return err
+  }
+
+  expected, err := readChecksum(path)
+  if err != nil {
+    return err
+  }
+  if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+    return fmt.Errorf("Snapshot %v is corrupt: checksum %v, expected %v", path, actual, expected)
+  }
+
+  var restored DBStructure
+  if err := json.Unmarshal(data, &restored); err != nil {
+    return err
+  }
+  restored = restored.clone()
+
+  pending, err := pendingMigrations(restored.SchemaVersion)
+  if err != nil {
+    return err
+  }
+  if err := applyMigrations(&restored, pending); err != nil {
+    return err
+  }
+
+  return db.replace("Restore", restored)
+}
+
+// readChecksum reads the checksum stored next to a snapshot
+func readChecksum(path string) (string, error) {
+  data, err := os.ReadFile(path + checksumSuffix)
+  if err != nil {
+    return "", fmt.Errorf("Unable to read checksum for %v: %v", path, err)
+  }
+  fields := strings.Fields(string(data))
+  if len(fields) == 0 {
+    return "", fmt.Errorf("Checksum file for %v is empty", path)
+  }
+  return fields[0], nil
+}
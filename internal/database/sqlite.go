@@ -0,0 +1,232 @@
+package database
+
+import (
+  "fmt"
+  "os"
+  "database/sql"
+  "encoding/json"
+  _ "modernc.org/sqlite"
+)
+
+// sqliteStorage keeps the database in a local SQLite file.
+// Every collection in DBStructure gets its own table of
+// (id, data) rows, and any other top level fields go in a
+// key/value meta table. Lookups are served from the in-memory
+// indexes, so the tables aren't indexed beyond their ids.
+type sqliteStorage struct {
+  path string
+  conn *sql.DB
+}
+
+// NewSQLiteDB creates a database backed by a SQLite file,
+// creating the file and tables if they don't exist
+func NewSQLiteDB(path string) (*DB, error) {
+  return newSQLiteDB(path, Options{})
+}
+
+func newSQLiteDB(path string, options Options) (*DB, error) {
+  dsn := path
+  if options.MigrationDryRun {
+    // Read only, so a dry run can't create or change the file
+    dsn = "file:" + path + "?mode=ro"
+  }
+  conn, err := sql.Open("sqlite", dsn)
+  if err != nil {
+    return nil, err
+  }
+  // A single connection keeps writers from tripping over SQLITE_BUSY
+  conn.SetMaxOpenConns(1)
+
+  db, err := newDB(path, &sqliteStorage{path: path, conn: conn}, options)
+  if err != nil {
+    conn.Close()
+    return nil, err
+  }
+  return db, nil
+}
+
+// ensure creates any missing tables
+func (s *sqliteStorage) ensure() error {
+  tx, err := s.conn.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS meta (key TEXT PRIMARY KEY, value TEXT NOT NULL)`); err != nil {
+    return err
+  }
+  for _, table := range collections() {
+    create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, data TEXT NOT NULL)`, table)
+    if _, err := tx.Exec(create); err != nil {
+      return err
+    }
+  }
+  return tx.Commit()
+}
+
+// sqliteUpsert returns the statement that writes a record to table,
+// taking the id and data as its two arguments
+func sqliteUpsert(table string) string {
+  return fmt.Sprintf(`INSERT OR REPLACE INTO %s (id, data) VALUES (?, ?)`, table)
+}
+
+// sqliteSetMeta is the statement that writes a top level field
+const sqliteSetMeta = `INSERT INTO meta (key, value) VALUES (?, ?)
+  ON CONFLICT(key) DO UPDATE SET value = excluded.value`
+
+// exists reports whether the database file is there
+func (s *sqliteStorage) exists() (bool, error) {
+  _, err := os.Stat(s.path)
+  if os.IsNotExist(err) {
+    return false, nil
+  }
+  return err == nil, err
+}
+
+// load reads every table back into a DBStructure
+func (s *sqliteStorage) load() (DBStructure, error) {
+  fields := map[string]json.RawMessage{}
+
+  for _, table := range collections() {
+    rows, err := s.conn.Query(fmt.Sprintf(`SELECT id, data FROM %s`, table))
+    if err != nil {
+      return DBStructure{}, err
+    }
+
+    records := map[string]json.RawMessage{}
+    for rows.Next() {
+      var id, data string
+      if err := rows.Scan(&id, &data); err != nil {
+        rows.Close()
+        return DBStructure{}, err
+      }
+      records[id] = json.RawMessage(data)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+      return DBStructure{}, err
+    }
+
+    encoded, err := json.Marshal(records)
+    if err != nil {
+      return DBStructure{}, err
+    }
+    fields[table] = encoded
+  }
+
+  rows, err := s.conn.Query(`SELECT key, value FROM meta`)
+  if err != nil {
+    return DBStructure{}, err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    var key, value string
+    if err := rows.Scan(&key, &value); err != nil {
+      return DBStructure{}, err
+    }
+    fields[key] = json.RawMessage(value)
+  }
+  if err := rows.Err(); err != nil {
+    return DBStructure{}, err
+  }
+
+  data, err := json.Marshal(fields)
+  if err != nil {
+    return DBStructure{}, err
+  }
+
+  var dbStructure DBStructure
+  if err := json.Unmarshal(data, &dbStructure); err != nil {
+    return DBStructure{}, err
+  }
+  return dbStructure, nil
+}
+
+// write replaces the contents of every table inside one transaction.
+// Updates only need writeChanges; this is for rewriting everything,
+// as migrations do.
+func (s *sqliteStorage) write(dbStructure DBStructure) error {
+  data, err := json.Marshal(dbStructure)
+  if err != nil {
+    return err
+  }
+
+  fields := map[string]json.RawMessage{}
+  if err := json.Unmarshal(data, &fields); err != nil {
+    return err
+  }
+
+  tx, err := s.conn.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  for _, table := range collections() {
+    records := map[string]json.RawMessage{}
+    if encoded, ok := fields[table]; ok && string(encoded) != "null" {
+      if err := json.Unmarshal(encoded, &records); err != nil {
+        return err
+      }
+    }
+    delete(fields, table)
+
+    if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s`, table)); err != nil {
+      return err
+    }
+    insert := sqliteUpsert(table)
+    for id, record := range records {
+      if _, err := tx.Exec(insert, id, string(record)); err != nil {
+        return err
+      }
+    }
+  }
+
+  for key, value := range fields {
+    if _, err := tx.Exec(sqliteSetMeta, key, string(value)); err != nil {
+      return err
+    }
+  }
+
+  return tx.Commit()
+}
+
+// writeChanges writes just the rows a mutation changed, inside one
+// transaction
+func (s *sqliteStorage) writeChanges(changes []journalChange) error {
+  tx, err := s.conn.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  for _, change := range changes {
+    var err error
+    switch {
+    case change.Id == "":
+      _, err = tx.Exec(sqliteSetMeta, change.Table, string(change.Data))
+    case !isCollection(change.Table):
+      err = fmt.Errorf("Unknown table: %v", change.Table)
+    case change.Delete:
+      _, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, change.Table), change.Id)
+    default:
+      _, err = tx.Exec(sqliteUpsert(change.Table), change.Id, string(change.Data))
+    }
+    if err != nil {
+      return err
+    }
+  }
+
+  return tx.Commit()
+}
+
+// backup writes a consistent copy of the database file to dest
+func (s *sqliteStorage) backup(dest string) error {
+  _, err := s.conn.Exec(`VACUUM INTO ?`, dest)
+  return err
+}
+
+func (s *sqliteStorage) close() error {
+  return s.conn.Close()
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+  "testing"
+  "database/sql"
+  "path/filepath"
+)
+
+func TestSQLiteWritesChanges(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "database.db")
+  db, err := NewSQLiteDB(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  user, err := db.CreateUser(User{Email: "sqlite@example.com"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  first, err := db.CreateChirp("First", user.Id)
+  if err != nil {
+    t.Fatal(err)
+  }
+  second, err := db.CreateChirp("Second", user.Id)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, _, err := db.Rechirp(first.Id, user.Id); err != nil {
+    t.Fatal(err)
+  }
+  if _, _, err := db.Unrechirp(first.Id, user.Id); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := db.EditChirp(second.Id, "Second, edited"); err != nil {
+    t.Fatal(err)
+  }
+  if err := db.Close(); err != nil {
+    t.Fatal(err)
+  }
+
+  reopened, err := NewSQLiteDB(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer reopened.Close()
+
+  chirps, err := reopened.ListChirpsByAuthor(user.Id)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(chirps) != 2 || chirps[0].Body != "First" || chirps[1].Body != "Second, edited" {
+    t.Errorf("Got %+v, expected the first chirp and the edited second one", chirps)
+  }
+  if found, err := reopened.FindUserByEmail("sqlite@example.com"); err != nil || found.Id != user.Id {
+    t.Errorf("Got %+v, %v, expected user %v", found, err, user.Id)
+  }
+}
+
+func TestSQLiteLoadsExistingTables(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "database.db")
+  conn, err := sql.Open("sqlite", path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  statements := []string{
+    `CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT NOT NULL)`,
+    `CREATE TABLE users (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+    `INSERT INTO users (id, data) VALUES ('1', '{"id": 1, "email": "old@example.com", "refresh_token": {"refresh_token": "abc"}}')`,
+    `INSERT INTO meta (key, value) VALUES ('schema_version', '4')`,
+  }
+  for _, statement := range statements {
+    if _, err := conn.Exec(statement); err != nil {
+      t.Fatal(err)
+    }
+  }
+  conn.Close()
+
+  db, err := NewSQLiteDB(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer db.Close()
+
+  if found, err := db.FindRefreshToken("abc"); err != nil || found.Id != 1 {
+    t.Errorf("Got %+v, %v, expected user 1", found, err)
+  }
+}
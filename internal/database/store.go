@@ -0,0 +1,145 @@
+package database
+
+import (
+  "fmt"
+  "time"
+  "reflect"
+  "strings"
+)
+
+// Store is the set of operations the API needs from a database backend
+type Store interface {
+  FindUserById(id int) (User, error)
+  FindUser(user User) (User, error)
+  FindUserByEmail(email string) (User, error)
+  CreateUser(user User) (User, error)
+  UpdateUser(user User) (User, error)
+  FollowUser(followerId int, followeeId int) (Follow, error)
+  UnfollowUser(followerId int, followeeId int) error
+  ListFollowers(userId int) ([]User, error)
+  ListFollowing(userId int) ([]User, error)
+  CreateConversation(userId int, otherId int) (Conversation, error)
+  GetConversation(conversationId int, userId int) (Conversation, error)
+  ListConversations(userId int, limit int, cursor string) (ConversationPage, error)
+  SendMessage(conversationId int, senderId int, body string) (Message, error)
+  ListMessages(query MessageQuery) (MessagePage, error)
+  MarkConversationRead(conversationId int, userId int, messageId int) (Conversation, error)
+  CreateRefreshToken(user User, refreshToken RefreshToken) (RefreshToken, error)
+  FindRefreshToken(refreshToken string) (User, error)
+  DeleteRefreshToken(refreshToken string) error
+  CreateChirp(body string, author_id int) (Chirp, error)
+  CreateReply(body string, authorId int, inReplyTo int) (Chirp, error)
+  CreateChirpWithAttachments(body string, authorId int, inReplyTo int, attachments []Attachment) (Chirp, error)
+  GetThread(id int, depth int) (ThreadNode, error)
+  LikeChirp(chirpId int, userId int) (Chirp, bool, error)
+  UnlikeChirp(chirpId int, userId int) (Chirp, bool, error)
+  Rechirp(chirpId int, userId int) (Chirp, bool, error)
+  Unrechirp(chirpId int, userId int) (Chirp, bool, error)
+  GetChirps() ([]Chirp, error)
+  ListChirpsByAuthor(authorId int) ([]Chirp, error)
+  ListChirps(query ChirpQuery) (ChirpPage, error)
+  Timeline(query TimelineQuery) (ChirpPage, error)
+  SearchChirps(query SearchQuery) (SearchPage, error)
+  FindChirpById(id int) (Chirp, error)
+  FindListedChirpById(id int) (Chirp, error)
+  FindChirpByAttachment(key string) (Chirp, error)
+  DeleteChirp(id int, deletedBy int) error
+  UndeleteChirp(id int) (Chirp, error)
+  EditChirp(id int, body string) (Chirp, error)
+  ListChirpRevisions(id int) ([]ChirpRevision, error)
+  RecordModerationResult(result ModerationResult) (ModerationResult, error)
+  ReportChirp(chirpId int, reporterId int, reason string) (Report, error)
+  ModerationQueue() ([]ModerationQueueItem, error)
+  ModerateChirp(chirpId int, moderatorId int, action string, note string) (ModerationLogEntry, error)
+  ListModerationLog(chirpId int) ([]ModerationLogEntry, error)
+  Notify(notification Notification) (Notification, bool, error)
+  ListNotifications(query NotificationQuery) (NotificationPage, error)
+  MarkNotificationRead(id int, userId int) (Notification, error)
+  MarkAllNotificationsRead(userId int) (int, error)
+  FindLinkPreview(url string) (LinkPreview, error)
+  SaveLinkPreview(preview LinkPreview) (LinkPreview, error)
+  PurgeChirps(deletedBefore time.Time) ([]Chirp, error)
+  View(fn func(*DBStructure) error) error
+  Update(fn func(*DBStructure) error) error
+  Flush() error
+  Migrations() MigrationReport
+  Snapshot(dir string) (Snapshot, error)
+  Restore(path string) error
+  Reset() error
+  Close() error
+}
+
+// storage reads and writes a whole DBStructure for a DB
+type storage interface {
+  ensure() error
+  // exists reports whether there is a database to load without
+  // creating one
+  exists() (bool, error)
+  load() (DBStructure, error)
+  write(dbStructure DBStructure) error
+  close() error
+}
+
+// incrementalStorage is implemented by storage backends that can
+// write just the records a mutation changed
+type incrementalStorage interface {
+  writeChanges(changes []journalChange) error
+}
+
+// journaledStorage is implemented by storage backends that
+// record every mutation before writing it
+type journaledStorage interface {
+  journal(op string, seq int, changes []journalChange) error
+  // replay applies the journal entries dbStructure is missing,
+  // without writing anything
+  replay(dbStructure DBStructure) (DBStructure, bool, error)
+  // checkpoint drops the journal entries up to seq once storage
+  // holds them
+  checkpoint(seq int) error
+}
+
+// collections returns the json name of every map field in DBStructure
+func collections() []string {
+  names := []string{}
+  t := reflect.TypeOf(DBStructure{})
+  for i := 0; i < t.NumField(); i++ {
+    field := t.Field(i)
+    if field.Type.Kind() != reflect.Map {
+      continue
+    }
+    if name := jsonName(field); name != "" {
+      names = append(names, name)
+    }
+  }
+  return names
+}
+
+// jsonName returns the name a struct field is encoded under,
+// or "" if it isn't encoded
+func jsonName(field reflect.StructField) string {
+  name := strings.Split(field.Tag.Get("json"), ",")[0]
+  if name == "-" {
+    return ""
+  }
+  return name
+}
+
+const (
+  BackendJSON = "json"
+  BackendSQLite = "sqlite"
+  BackendMemory = "memory"
+)
+
+// Open creates a Store for the given backend.
+// path is ignored by the memory backend.
+func Open(backend string, path string, options Options) (Store, error) {
+  switch backend {
+  case BackendJSON:
+    return newDB(path, &jsonStorage{path: path}, options)
+  case BackendSQLite:
+    return newSQLiteDB(path, options)
+  case BackendMemory:
+    return newDB("", memoryStorage{}, options)
+  }
+  return nil, fmt.Errorf("Unknown database backend: %v", backend)
+}
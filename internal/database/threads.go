@@ -0,0 +1,90 @@
+package database
+
+import (
+  "fmt"
+)
+
+// CreateReply creates a chirp replying to another one, which has to
+// be listed
+func (db *DB) CreateReply(body string, authorId int, inReplyTo int) (Chirp, error) {
+  return db.createChirp("CreateReply", body, authorId, inReplyTo, nil)
+}
+
+// ThreadNode is a chirp in a conversation with its replies. A chirp
+// that was deleted, hidden or purged is left as an unavailable node so
+// its replies keep their place in the thread.
+type ThreadNode struct {
+  Id int `json:"id"`
+  *Chirp
+  Unavailable bool `json:"unavailable,omitempty"`
+  Replies []ThreadNode `json:"replies"`
+  // MoreReplies counts the replies left out below the depth limit
+  MoreReplies int `json:"more_replies,omitempty"`
+}
+
+// GetThread returns the whole conversation a chirp is part of, starting
+// from the chirp that began it. Depth counts from the requested chirp,
+// so replies more than depth levels below it are left out while the
+// chain above it is always there. Replies come oldest first.
+func (db *DB) GetThread(id int, depth int) (ThreadNode, error) {
+  var thread ThreadNode
+  err := db.View(func(dbStructure *DBStructure) error {
+    chirp, ok := dbStructure.Chirps[id]
+    if !ok || !chirp.IsListed() {
+      return fmt.Errorf("No chirp found with id: %v", id)
+    }
+
+    // Walk up to the start of the conversation. A parent that has been
+    // purged becomes the start, as an unavailable node.
+    rootId := id
+    levels := 0
+    seen := map[int]bool{id: true}
+    for {
+      parent, ok := dbStructure.Chirps[rootId]
+      if !ok || parent.InReplyTo == 0 || seen[parent.InReplyTo] {
+        break
+      }
+      rootId = parent.InReplyTo
+      seen[rootId] = true
+      levels++
+    }
+
+    thread = dbStructure.threadNode(rootId, depth+levels, map[int]bool{})
+    return nil
+  })
+  if err != nil {
+    return ThreadNode{}, err
+  }
+  return thread, nil
+}
+
+// threadNode builds the node for id and its replies down to depth
+func (s *DBStructure) threadNode(id int, depth int, seen map[int]bool) ThreadNode {
+  seen[id] = true
+  node := ThreadNode{Id: id, Replies: []ThreadNode{}}
+  if chirp, ok := s.Chirps[id]; ok && chirp.IsListed() {
+    chirp = s.expand(chirp)
+    node.Chirp = &chirp
+  } else {
+    node.Unavailable = true
+  }
+
+  for _, replyId := range s.indexes.repliesByChirp[id] {
+    if seen[replyId] {
+      continue
+    }
+    if depth <= 0 {
+      if s.Chirps[replyId].IsListed() {
+        node.MoreReplies++
+      }
+      continue
+    }
+    reply := s.threadNode(replyId, depth-1, seen)
+    // Drop unavailable chirps with nothing under them
+    if reply.Unavailable && len(reply.Replies) == 0 && reply.MoreReplies == 0 {
+      continue
+    }
+    node.Replies = append(node.Replies, reply)
+  }
+  return node
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+  "fmt"
+  "slices"
+)
+
+// TimelineQuery selects a page of a user's home timeline, which holds
+// their own chirps and rechirps and those of everyone they follow,
+// newest first
+type TimelineQuery struct {
+  UserId int
+  // Limit is the page size. Zero returns everything after the cursor.
+  Limit int
+  // Cursor continues from a NextCursor or PrevCursor of an earlier page
+  Cursor string
+}
+
+// Timeline returns a page of a user's home timeline. Rather than
+// storing a copy of every chirp in each follower's timeline, the page
+// is merged from the sorted per-author indexes starting at the cursor,
+// so a page costs about the number of followed authors plus the page
+// size, and deleting, hiding or unfollowing needs no clean up.
+func (db *DB) Timeline(query TimelineQuery) (ChirpPage, error) {
+  var cursor *chirpCursor
+  if query.Cursor != "" {
+    decoded, err := decodeCursor(query.Cursor)
+    if err != nil {
+      return ChirpPage{}, err
+    }
+    cursor = &decoded
+  }
+
+  page := ChirpPage{Chirps: []Chirp{}}
+  err := db.View(func(dbStructure *DBStructure) error {
+    if _, ok := dbStructure.Users[query.UserId]; !ok {
+      return fmt.Errorf("No user found with id: %v", query.UserId)
+    }
+
+    authors := []orderedIds{{ids: dbStructure.indexes.chirpsByAuthor[query.UserId], descending: true}}
+    for followeeId := range dbStructure.indexes.following[query.UserId] {
+      authors = append(authors, orderedIds{ids: dbStructure.indexes.chirpsByAuthor[followeeId], descending: true})
+    }
+    full := func(ids []int) bool {
+      return query.Limit > 0 && len(ids) >= query.Limit
+    }
+
+    ids := []int{}
+    if cursor != nil && cursor.Before {
+      // Walk back towards newer chirps from just before the cursor,
+      // taking the oldest of each author's next chirp every time
+      ends := make([]int, len(authors))
+      for i, author := range authors {
+        ends[i] = author.countBefore(cursor.Id)
+      }
+      for !full(ids) {
+        next := -1
+        for i, author := range authors {
+          if ends[i] > 0 && (next < 0 || author.at(ends[i]-1) < authors[next].at(ends[next]-1)) {
+            next = i
+          }
+        }
+        if next < 0 {
+          break
+        }
+        ends[next]--
+        ids = append(ids, authors[next].at(ends[next]))
+      }
+      slices.Reverse(ids)
+    } else {
+      starts := make([]int, len(authors))
+      if cursor != nil {
+        for i, author := range authors {
+          starts[i] = author.countThrough(cursor.Id)
+        }
+      }
+      for !full(ids) {
+        next := -1
+        for i, author := range authors {
+          if starts[i] < author.len() && (next < 0 || author.at(starts[i]) > authors[next].at(starts[next])) {
+            next = i
+          }
+        }
+        if next < 0 {
+          break
+        }
+        ids = append(ids, authors[next].at(starts[next]))
+        starts[next]++
+      }
+    }
+    if len(ids) == 0 {
+      return nil
+    }
+
+    for _, id := range ids {
+      page.Chirps = append(page.Chirps, dbStructure.expand(dbStructure.Chirps[id]))
+    }
+
+    first, last := ids[0], ids[len(ids)-1]
+    for _, author := range authors {
+      if author.countThrough(last) < author.len() {
+        page.NextCursor = encodeCursor(chirpCursor{Id: last})
+      }
+      if author.countBefore(first) > 0 {
+        page.PrevCursor = encodeCursor(chirpCursor{Id: first, Before: true})
+      }
+    }
+    return nil
+  })
+  if err != nil {
+    return ChirpPage{}, err
+  }
+  return page, nil
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+  "fmt"
+  "time"
+)
+
+// IsDeleted reports whether the chirp has been tombstoned
+func (c Chirp) IsDeleted() bool {
+  return c.DeletedAt != nil
+}
+
+// FindChirpById returns a chirp, including one that has been deleted.
+// Callers decide whether a tombstoned chirp should be shown.
+func (db *DB) FindChirpById(id int) (Chirp, error) {
+  var foundChirp Chirp
+  err := db.View(func(dbStructure *DBStructure) error {
+    chirp, ok := dbStructure.Chirps[id]
+    if !ok {
+      return fmt.Errorf("No chirp found with id: %v", id)
+    }
+    foundChirp = dbStructure.expand(chirp)
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, err
+  }
+  return foundChirp, nil
+}
+
+// FindListedChirpById returns a chirp only if it would appear in feeds,
+// so not if it's deleted or hidden, or if it's a rechirp of a chirp
+// that is
+func (db *DB) FindListedChirpById(id int) (Chirp, error) {
+  var foundChirp Chirp
+  err := db.View(func(dbStructure *DBStructure) error {
+    chirp, ok := dbStructure.Chirps[id]
+    if !ok || !dbStructure.isListed(chirp) {
+      return fmt.Errorf("No chirp found with id: %v", id)
+    }
+    foundChirp = dbStructure.expand(chirp)
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, err
+  }
+  return foundChirp, nil
+}
+
+// UndeleteChirp clears a chirp's tombstone
+func (db *DB) UndeleteChirp(id int) (Chirp, error) {
+  var chirp Chirp
+  err := db.update("UndeleteChirp", func(dbStructure *DBStructure) error {
+    foundChirp, ok := dbStructure.Chirps[id]
+    if !ok {
+      return fmt.Errorf("No chirp found with id: %v", id)
+    }
+    if !foundChirp.IsDeleted() {
+      return fmt.Errorf("Chirp %v is not deleted", id)
+    }
+
+    foundChirp.DeletedAt = nil
+    foundChirp.DeletedBy = 0
+    chirpsTable.put(dbStructure, id, foundChirp)
+    chirp = dbStructure.expand(foundChirp)
+    return nil
+  })
+  if err != nil {
+    return Chirp{}, err
+  }
+  return chirp, nil
+}
+
+// PurgeChirps removes chirps that were deleted before deletedBefore,
+// along with their revisions, reports, likes and rechirps, for good and
+// returns the chirps it removed, so whatever they had attached can be
+// cleaned up too. The moderation log keeps its entries for them.
+func (db *DB) PurgeChirps(deletedBefore time.Time) ([]Chirp, error) {
+  // Check under the read lock first, so a purge with nothing to do
+  // doesn't copy and write the whole database
+  purgeable := 0
+  db.View(func(dbStructure *DBStructure) error {
+    for _, chirp := range dbStructure.Chirps {
+      if chirp.IsDeleted() && chirp.DeletedAt.Before(deletedBefore) {
+        purgeable++
+      }
+    }
+    return nil
+  })
+  if purgeable == 0 {
+    return []Chirp{}, nil
+  }
+
+  var purged []Chirp
+  err := db.update("PurgeChirps", func(dbStructure *DBStructure) error {
+    purged = []Chirp{}
+    reportsByChirp := map[int][]int{}
+    for reportId, report := range dbStructure.Reports {
+      reportsByChirp[report.ChirpId] = append(reportsByChirp[report.ChirpId], reportId)
+    }
+    notificationsByChirp := map[int][]int{}
+    for notificationId, notification := range dbStructure.Notifications {
+      if notification.ChirpId != 0 {
+        notificationsByChirp[notification.ChirpId] = append(notificationsByChirp[notification.ChirpId], notificationId)
+      }
+    }
+    for id, chirp := range dbStructure.Chirps {
+      if chirp.IsDeleted() && chirp.DeletedAt.Before(deletedBefore) {
+        chirpsTable.remove(dbStructure, id)
+        for _, revisionId := range dbStructure.indexes.revisionsByChirp[id] {
+          revisionsTable.remove(dbStructure, revisionId)
+        }
+        for _, reportId := range reportsByChirp[id] {
+          reportsTable.remove(dbStructure, reportId)
+        }
+        for _, likeId := range dbStructure.indexes.likesByChirp[id] {
+          likesTable.remove(dbStructure, likeId)
+        }
+        for _, notificationId := range notificationsByChirp[id] {
+          notificationsTable.remove(dbStructure, notificationId)
+        }
+        for _, rechirpId := range dbStructure.indexes.allRechirpsByChirp[id] {
+          chirpsTable.remove(dbStructure, rechirpId)
+        }
+        purged = append(purged, chirp)
+      }
+    }
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+  return purged, nil
+}
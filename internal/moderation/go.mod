@@ -0,0 +1,5 @@
+module github.com/kekekekyle/moderation
+
+go 1.23.0
+
+require golang.org/x/text v0.17.0
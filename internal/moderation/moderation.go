@@ -0,0 +1,121 @@
+// Package moderation checks chirp bodies against a chain of filters
+// and decides what happens to them.
+package moderation
+
+import (
+  "fmt"
+  "sort"
+  "sync"
+  "strings"
+)
+
+// Action is what a rule wants done with text it matches
+type Action string
+
+const (
+  // ActionMask replaces the matched text with asterisks
+  ActionMask Action = "mask"
+  // ActionReject refuses the whole chirp
+  ActionReject Action = "reject"
+  // ActionFlag lets the chirp through but marks it for review
+  ActionFlag Action = "flag"
+)
+
+// ParseAction checks that s names an action
+func ParseAction(s string) (Action, error) {
+  switch action := Action(strings.ToLower(s)); action {
+  case ActionMask, ActionReject, ActionFlag:
+    return action, nil
+  }
+  return "", fmt.Errorf("Unknown moderation action: %v", s)
+}
+
+// Match is a piece of a body a rule matched. Start and End are byte
+// offsets into the original body.
+type Match struct {
+  Rule string `json:"rule"`
+  Action Action `json:"action"`
+  Text string `json:"text"`
+  Start int `json:"start"`
+  End int `json:"end"`
+}
+
+// Filter finds the parts of a text it objects to
+type Filter interface {
+  Check(text *Text) []Match
+}
+
+// Result is the outcome of running a body through a Chain
+type Result struct {
+  // Body is the original body with masked matches replaced
+  Body string
+  Rejected bool
+  Flagged bool
+  Matches []Match
+}
+
+// Chain runs a body through each of its filters in turn
+type Chain struct {
+  mux sync.RWMutex
+  filters []Filter
+}
+
+// NewChain creates a chain of filters
+func NewChain(filters ...Filter) *Chain {
+  return &Chain{filters: filters}
+}
+
+// Use adds a filter to the end of the chain
+func (c *Chain) Use(filter Filter) {
+  c.mux.Lock()
+  defer c.mux.Unlock()
+  c.filters = append(c.filters, filter)
+}
+
+// Moderate runs body through every filter and applies their actions
+func (c *Chain) Moderate(body string) Result {
+  c.mux.RLock()
+  defer c.mux.RUnlock()
+
+  text := NewText(body)
+  result := Result{Body: body, Matches: []Match{}}
+  for _, filter := range c.filters {
+    result.Matches = append(result.Matches, filter.Check(text)...)
+  }
+  sort.SliceStable(result.Matches, func(i, j int) bool {
+    return result.Matches[i].Start < result.Matches[j].Start
+  })
+
+  masks := []Match{}
+  for _, match := range result.Matches {
+    switch match.Action {
+    case ActionReject:
+      result.Rejected = true
+    case ActionFlag:
+      result.Flagged = true
+    case ActionMask:
+      masks = append(masks, match)
+    }
+  }
+  result.Body = mask(body, masks)
+  return result
+}
+
+// mask replaces each match in body with asterisks, merging any that
+// overlap. matches must be sorted by Start.
+func mask(body string, matches []Match) string {
+  masked := strings.Builder{}
+  last := 0
+  for _, match := range matches {
+    if match.End <= last {
+      continue
+    }
+    if match.Start >= last {
+      masked.WriteString(body[last:match.Start])
+      masked.WriteString("****")
+    }
+    last = match.End
+  }
+  masked.WriteString(body[last:])
+  return masked.String()
+}
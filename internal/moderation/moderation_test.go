@@ -0,0 +1,261 @@
+package moderation
+
+import (
+  "os"
+  "time"
+  "testing"
+  "path/filepath"
+)
+
+// writeFile writes contents to name in a temporary directory
+// and returns its path
+func writeFile(t *testing.T, name string, contents string) string {
+  t.Helper()
+  path := filepath.Join(t.TempDir(), name)
+  if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+    t.Fatal(err)
+  }
+  return path
+}
+
+// newTestChain is a chain with a word list and rules like the ones
+// the server ships with
+func newTestChain(t *testing.T) *Chain {
+  t.Helper()
+  words, err := NewWordList(writeFile(t, "words.txt", "kerfuffle\nsharbert flag\nfornax reject # always\n"), ActionMask)
+  if err != nil {
+    t.Fatal(err)
+  }
+  rules, err := NewRuleSet(writeFile(t, "rules.json", `[
+    {"name": "follower-spam", "pattern": "\\b(free|cheap|buy)\\s+(followers|likes)\\b", "action": "flag"},
+    {"name": "kerf", "pattern": "kerf", "action": "mask"},
+    {"name": "fuff", "pattern": "fuff", "action": "mask"},
+    {"name": "zz", "pattern": "z", "action": "mask"}
+  ]`))
+  if err != nil {
+    t.Fatal(err)
+  }
+  return NewChain(words, rules)
+}
+
+func TestNewText(t *testing.T) {
+  tests := []struct {
+    name string
+    body string
+    normalized string
+  }{
+    {"plain", "Kerfuffle", "kerfuffle"},
+    {"accented", "Kérfüfflé", "kerfuffle"},
+    {"full width", "Ｋｅｒｆｕｆｆｌｅ", "kerfuffle"},
+    {"ligature", "kerfuﬀle", "kerfuffle"},
+    {"emoji", "😀 Hi", "😀 hi"},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      text := NewText(test.body)
+      if text.Normalized != test.normalized {
+        t.Errorf("Normalized %q to %q, expected %q", test.body, text.Normalized, test.normalized)
+      }
+      if start, end := text.Span(0, len(text.Normalized)); start != 0 || end != len(test.body) {
+        t.Errorf("The whole of %q spans %v to %v, expected 0 to %v", test.body, start, end, len(test.body))
+      }
+    })
+  }
+}
+
+func TestSpan(t *testing.T) {
+  tests := []struct {
+    name string
+    body string
+    start, end int
+    original string
+  }{
+    {"after an accent", "naïve kerfuffle", 6, 15, "kerfuffle"},
+    {"full width", "a Ｋｅｒｆ b", 2, 6, "Ｋｅｒｆ"},
+    {"inside a ligature", "kerfuﬀle", 5, 6, "ﬀ"},
+    {"across a ligature", "kerfuﬀle", 4, 8, "uﬀl"},
+    {"after an emoji", "😀 kerfuffle", 5, 14, "kerfuffle"},
+    {"empty", "kerfuffle", 3, 3, ""},
+    {"past the end", "kerfuffle", 3, 20, ""},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      text := NewText(test.body)
+      start, end := text.Span(test.start, test.end)
+      if got := text.Original[start:end]; got != test.original {
+        t.Errorf("Span(%v, %v) of %q is %q, expected %q", test.start, test.end, test.body, got, test.original)
+      }
+    })
+  }
+}
+
+func TestModerate(t *testing.T) {
+  chain := newTestChain(t)
+  tests := []struct {
+    name string
+    body string
+    masked string
+    rejected bool
+    flagged bool
+  }{
+    {"clean", "Hello, world", "Hello, world", false, false},
+    {"word", "What a kerfuffle", "What a ****", false, false},
+    {"capitals and punctuation", "What a KERFUFFLE!", "What a ****!", false, false},
+    {"accented", "What a kérfüfflé", "What a ****", false, false},
+    {"full width", "What a ｋｅｒｆｕｆｆｌｅ", "What a ****", false, false},
+    {"ligature", "What a kerfuﬀle", "What a ****", false, false},
+    {"spelled out", "What a k.e.r.f.u.f.f.l.e!", "What a ****!", false, false},
+    {"after multi-byte text", "Ünïcödé 😀 kerfuffle", "Ünïcödé 😀 ****", false, false},
+    {"adjacent words", "kerfuffle kerfuffle", "**** ****", false, false},
+    {"adjacent in one chunk", "kerfuffle,kerfuffle", "****,****", false, false},
+    {"adjacent rules", "zz", "********", false, false},
+    {"overlapping rules", "kerfuff", "****", false, false},
+    {"rule inside a word", "Kerfuffles", "****les", false, false},
+    {"rule inside a ligature", "kerﬀ", "****", false, false},
+    {"flagged word", "Sharbert!", "Sharbert!", false, true},
+    {"rejected word", "fornax", "fornax", true, false},
+    {"flagged rule", "Buy followers here", "Buy followers here", false, true},
+    {"flagged rule, accented", "Frée likes", "Frée likes", false, true},
+    {"rule needs word boundaries", "buyfollowers", "buyfollowers", false, false},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      result := chain.Moderate(test.body)
+      if result.Body != test.masked {
+        t.Errorf("Masked %q to %q, expected %q", test.body, result.Body, test.masked)
+      }
+      if result.Rejected != test.rejected || result.Flagged != test.flagged {
+        t.Errorf("Got rejected %v and flagged %v, expected %v and %v", result.Rejected, result.Flagged, test.rejected, test.flagged)
+      }
+      for _, match := range result.Matches {
+        if test.body[match.Start:match.End] != match.Text {
+          t.Errorf("Match %+v doesn't point at its text", match)
+        }
+      }
+    })
+  }
+}
+
+func TestMatchText(t *testing.T) {
+  chain := newTestChain(t)
+  tests := []struct {
+    body string
+    rule string
+    text string
+    start int
+  }{
+    {"Oh, ｋéｒｆｕｆｆｌｅ!", "word:kerfuffle", "ｋéｒｆｕｆｆｌｅ", 4},
+    {"naïve k.e.r.f.u.f.f.l.e.", "word:kerfuffle", "k.e.r.f.u.f.f.l.e", 7},
+    {"😀 BUY  Likes", "rule:follower-spam", "BUY  Likes", 5},
+  }
+  for _, test := range tests {
+    result := chain.Moderate(test.body)
+    found := false
+    for _, match := range result.Matches {
+      if match.Rule == test.rule {
+        found = true
+        if match.Text != test.text || match.Start != test.start {
+          t.Errorf("Matched %q at %v in %q, expected %q at %v", match.Text, match.Start, test.body, test.text, test.start)
+        }
+      }
+    }
+    if !found {
+      t.Errorf("%v didn't match %q: %+v", test.rule, test.body, result.Matches)
+    }
+  }
+}
+
+func TestWordListErrors(t *testing.T) {
+  tests := []struct {
+    name string
+    contents string
+  }{
+    {"unknown action", "kerfuffle obliterate\n"},
+    {"too many fields", "kerfuffle mask now\n"},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if _, err := NewWordList(writeFile(t, "words.txt", test.contents), ActionMask); err == nil {
+        t.Error("Loaded a bad word list")
+      }
+    })
+  }
+}
+
+func TestRuleSetErrors(t *testing.T) {
+  tests := []struct {
+    name string
+    contents string
+  }{
+    {"not a list", `{"name": "kerf"}`},
+    {"no name", `[{"pattern": "kerf", "action": "mask"}]`},
+    {"unknown action", `[{"name": "kerf", "pattern": "kerf", "action": "obliterate"}]`},
+    {"bad pattern", `[{"name": "kerf", "pattern": "(kerf", "action": "mask"}]`},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if _, err := NewRuleSet(writeFile(t, "rules.json", test.contents)); err == nil {
+        t.Error("Loaded bad rules")
+      }
+    })
+  }
+}
+
+func TestWatchReloadsWordList(t *testing.T) {
+  interval := WatchInterval
+  WatchInterval = 10 * time.Millisecond
+  defer func() { WatchInterval = interval }()
+
+  path := writeFile(t, "words.txt", "kerfuffle\n")
+  list, err := NewWordList(path, ActionMask)
+  if err != nil {
+    t.Fatal(err)
+  }
+  chain := NewChain(list)
+  stop := make(chan struct{})
+  stopped := make(chan struct{})
+  go func() {
+    list.Watch(stop)
+    close(stopped)
+  }()
+  defer func() {
+    close(stop)
+    <-stopped
+  }()
+
+  // rewrite replaces the list and moves its modification time on, as
+  // the file system may not tell writes in quick succession apart
+  modified := time.Now()
+  rewrite := func(contents string) {
+    if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+      t.Fatal(err)
+    }
+    modified = modified.Add(time.Second)
+    if err := os.Chtimes(path, modified, modified); err != nil {
+      t.Fatal(err)
+    }
+  }
+  waitFor := func(body string, masked string) {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for chain.Moderate(body).Body != masked {
+      if time.Now().After(deadline) {
+        t.Fatalf("Moderated %q to %q, expected %q", body, chain.Moderate(body).Body, masked)
+      }
+      time.Sleep(WatchInterval)
+    }
+  }
+
+  rewrite("sharbert\n")
+  waitFor("kerfuffle sharbert", "kerfuffle ****")
+
+  // A bad edit keeps the words from before it
+  rewrite("sharbert obliterate\n")
+  time.Sleep(10 * WatchInterval)
+  if got := chain.Moderate("sharbert").Body; got != "****" {
+    t.Errorf("Moderated %q after a bad edit, expected the old words to stay", got)
+  }
+
+  rewrite("kerfuffle\n")
+  waitFor("kerfuffle sharbert", "**** sharbert")
+}
@@ -0,0 +1,95 @@
+package moderation
+
+import (
+  "os"
+  "fmt"
+  "sync"
+  "regexp"
+  "encoding/json"
+)
+
+// Rule is a regular expression matched against the normalized body,
+// so patterns should be written in lower case without accents
+type Rule struct {
+  Name string `json:"name"`
+  Pattern string `json:"pattern"`
+  Action Action `json:"action"`
+  regexp *regexp.Regexp
+}
+
+// RuleSet matches regular expression rules loaded from a JSON file
+// holding a list of rules
+type RuleSet struct {
+  path string
+  mux sync.RWMutex
+  rules []Rule
+}
+
+// NewRuleSet loads rules from path
+func NewRuleSet(path string) (*RuleSet, error) {
+  set := &RuleSet{path: path}
+  if err := set.Reload(); err != nil {
+    return nil, err
+  }
+  return set, nil
+}
+
+// Reload reads the file again, keeping the old rules if it can't
+func (s *RuleSet) Reload() error {
+  data, err := os.ReadFile(s.path)
+  if err != nil {
+    return err
+  }
+  rules := []Rule{}
+  if err := json.Unmarshal(data, &rules); err != nil {
+    return fmt.Errorf("Couldn't read rules from %v: %v", s.path, err)
+  }
+  for i, rule := range rules {
+    if rule.Name == "" {
+      return fmt.Errorf("Rule %v in %v has no name", i, s.path)
+    }
+    action, err := ParseAction(string(rule.Action))
+    if err != nil {
+      return fmt.Errorf("Rule %v in %v: %v", rule.Name, s.path, err)
+    }
+    compiled, err := regexp.Compile(rule.Pattern)
+    if err != nil {
+      return fmt.Errorf("Rule %v in %v: %v", rule.Name, s.path, err)
+    }
+    rules[i].Action = action
+    rules[i].regexp = compiled
+  }
+
+  s.mux.Lock()
+  defer s.mux.Unlock()
+  s.rules = rules
+  return nil
+}
+
+// Watch reloads the rules whenever their file changes until stop is closed
+func (s *RuleSet) Watch(stop <-chan struct{}) {
+  watch(s.path, s.Reload, stop)
+}
+
+func (s *RuleSet) Check(text *Text) []Match {
+  s.mux.RLock()
+  defer s.mux.RUnlock()
+
+  matches := []Match{}
+  for _, rule := range s.rules {
+    for _, location := range rule.regexp.FindAllStringIndex(text.Normalized, -1) {
+      start, end := text.Span(location[0], location[1])
+      if start == end {
+        continue
+      }
+      matches = append(matches, Match{
+        Rule: "rule:" + rule.Name,
+        Action: rule.Action,
+        Text: text.Original[start:end],
+        Start: start,
+        End: end,
+      })
+    }
+  }
+  return matches
+}
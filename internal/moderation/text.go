@@ -0,0 +1,104 @@
+package moderation
+
+import (
+  "strings"
+  "unicode"
+  "unicode/utf8"
+
+  "golang.org/x/text/unicode/norm"
+)
+
+// Text is a chirp body alongside the normalized form filters match
+// against. Normalizing decomposes compatibility characters, drops
+// accents and lowercases, so "Ｋérfuffle" reads as "kerfuffle".
+type Text struct {
+  Original string
+  Normalized string
+  // spans holds, for each byte of Normalized, the range of the
+  // original rune it came from
+  spans []span
+}
+
+type span struct {
+  start, end int
+}
+
+// NewText normalizes body one rune at a time so every normalized byte
+// can be traced back to the original
+func NewText(body string) *Text {
+  text := &Text{Original: body}
+  normalized := strings.Builder{}
+  for i, r := range body {
+    original := span{i, i + utf8.RuneLen(r)}
+    for _, n := range normalizeRune(r) {
+      normalized.WriteRune(n)
+      for range utf8.RuneLen(n) {
+        text.spans = append(text.spans, original)
+      }
+    }
+  }
+  text.Normalized = normalized.String()
+  return text
+}
+
+// Normalize returns the normalized form of s without the offset mapping,
+// for preparing word lists and other things to compare against
+func Normalize(s string) string {
+  return NewText(s).Normalized
+}
+
+func normalizeRune(r rune) []rune {
+  runes := []rune{}
+  for _, n := range norm.NFKD.String(string(r)) {
+    if unicode.Is(unicode.Mn, n) {
+      continue
+    }
+    runes = append(runes, unicode.ToLower(n))
+  }
+  return runes
+}
+
+// Span maps a byte range of Normalized back to a byte range of Original
+func (t *Text) Span(start int, end int) (int, int) {
+  if start >= end || end > len(t.spans) {
+    return 0, 0
+  }
+  return t.spans[start].start, t.spans[end-1].end
+}
+
+// isWordRune reports whether r is part of a word rather than
+// punctuation or space
+func isWordRune(r rune) bool {
+  return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// word is a run of letters and digits in the normalized text
+type word struct {
+  text string
+  start, end int
+}
+
+// chunks splits the normalized text on whitespace and each chunk into
+// its words, so "K.e.r.f.u.f.f.l.e!" is one chunk of nine words
+func (t *Text) chunks() [][]word {
+  chunks := [][]word{}
+  current := []word{}
+  start := -1
+  for i, r := range t.Normalized + " " {
+    if isWordRune(r) {
+      if start < 0 {
+        start = i
+      }
+      continue
+    }
+    if start >= 0 {
+      current = append(current, word{t.Normalized[start:i], start, i})
+      start = -1
+    }
+    if unicode.IsSpace(r) && len(current) > 0 {
+      chunks = append(chunks, current)
+      current = []word{}
+    }
+  }
+  return chunks
+}
@@ -0,0 +1,45 @@
+package moderation
+
+import (
+  "os"
+  "log"
+  "time"
+)
+
+// WatchInterval is how often watched files are checked for changes
+var WatchInterval = 2 * time.Second
+
+// watch calls reload whenever the file at path is modified, logging
+// rather than returning errors so a bad edit doesn't stop the server
+func watch(path string, reload func() error, stop <-chan struct{}) {
+  modified := func() time.Time {
+    info, err := os.Stat(path)
+    if err != nil {
+      return time.Time{}
+    }
+    return info.ModTime()
+  }
+
+  // Leaving last unset reloads on the first tick, which picks up any
+  // change made between loading the file and starting to watch it
+  var last time.Time
+  ticker := time.NewTicker(WatchInterval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-stop:
+      return
+    case <-ticker.C:
+      current := modified()
+      if current.IsZero() || current.Equal(last) {
+        continue
+      }
+      last = current
+      if err := reload(); err != nil {
+        log.Printf("Couldn't reload %v: %v", path, err)
+        continue
+      }
+      log.Printf("Reloaded %v", path)
+    }
+  }
+}
@@ -0,0 +1,115 @@
+package moderation
+
+import (
+  "os"
+  "fmt"
+  "sync"
+  "bufio"
+  "strings"
+)
+
+// WordList matches whole words from a file. Each line holds a word and
+// optionally the action to take for it, and # starts a comment:
+//
+//   kerfuffle
+//   fornax reject
+//
+// Words are compared after normalization and punctuation inside a word
+// is ignored, so "Kerfuffle!" and "f.o.r.n.a.x" both match.
+type WordList struct {
+  path string
+  action Action
+  mux sync.RWMutex
+  words map[string]Action
+}
+
+// NewWordList loads a word list from path. Words without an action
+// of their own use action.
+func NewWordList(path string, action Action) (*WordList, error) {
+  list := &WordList{path: path, action: action}
+  if err := list.Reload(); err != nil {
+    return nil, err
+  }
+  return list, nil
+}
+
+// Reload reads the file again, keeping the old words if it can't
+func (l *WordList) Reload() error {
+  file, err := os.Open(l.path)
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  words := map[string]Action{}
+  scanner := bufio.NewScanner(file)
+  for line := 1; scanner.Scan(); line++ {
+    fields := strings.Fields(strings.SplitN(scanner.Text(), "#", 2)[0])
+    if len(fields) == 0 {
+      continue
+    }
+    if len(fields) > 2 {
+      return fmt.Errorf("Too many fields on line %v of %v", line, l.path)
+    }
+    action := l.action
+    if len(fields) == 2 {
+      action, err = ParseAction(fields[1])
+      if err != nil {
+        return fmt.Errorf("Line %v of %v: %v", line, l.path, err)
+      }
+    }
+    words[Normalize(fields[0])] = action
+  }
+  if err := scanner.Err(); err != nil {
+    return err
+  }
+
+  l.mux.Lock()
+  defer l.mux.Unlock()
+  l.words = words
+  return nil
+}
+
+// Watch reloads the list whenever its file changes until stop is closed
+func (l *WordList) Watch(stop <-chan struct{}) {
+  watch(l.path, l.Reload, stop)
+}
+
+func (l *WordList) Check(text *Text) []Match {
+  l.mux.RLock()
+  defer l.mux.RUnlock()
+
+  matches := []Match{}
+  add := func(w string, start int, end int) bool {
+    action, ok := l.words[w]
+    if !ok {
+      return false
+    }
+    start, end = text.Span(start, end)
+    matches = append(matches, Match{
+      Rule: "word:" + w,
+      Action: action,
+      Text: text.Original[start:end],
+      Start: start,
+      End: end,
+    })
+    return true
+  }
+
+  for _, chunk := range text.chunks() {
+    // Try the chunk with its punctuation taken out first, then its words
+    if len(chunk) > 1 {
+      joined := strings.Builder{}
+      for _, w := range chunk {
+        joined.WriteString(w.text)
+      }
+      if add(joined.String(), chunk[0].start, chunk[len(chunk)-1].end) {
+        continue
+      }
+    }
+    for _, w := range chunk {
+      add(w.text, w.start, w.end)
+    }
+  }
+  return matches
+}
@@ -0,0 +1,5 @@
+module github.com/kekekekyle/previews
+
+go 1.23.0
+
+require golang.org/x/net v0.28.0
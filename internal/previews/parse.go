@@ -0,0 +1,103 @@
+package previews
+
+import (
+  "io"
+  "strings"
+  "net/url"
+  "unicode/utf8"
+
+  "golang.org/x/net/html"
+  "golang.org/x/net/html/atom"
+)
+
+// Longest title and description kept, in runes
+const (
+  maxTitleLength = 300
+  maxDescriptionLength = 1000
+)
+
+// parse reads a preview from the head of a page. It stops at the body,
+// since metadata belongs in the head and pages can be large.
+func parse(page io.Reader, pageUrl *url.URL) (Preview, error) {
+  meta := map[string]string{}
+  title := ""
+  inTitle := false
+
+  tokenizer := html.NewTokenizer(page)
+  for done := false; !done; {
+    switch tokenizer.Next() {
+    case html.ErrorToken:
+      if err := tokenizer.Err(); err != io.EOF {
+        return Preview{}, err
+      }
+      done = true
+    case html.StartTagToken, html.SelfClosingTagToken:
+      token := tokenizer.Token()
+      switch token.DataAtom {
+      case atom.Title:
+        inTitle = title == ""
+      case atom.Meta:
+        key, content := "", ""
+        for _, attr := range token.Attr {
+          switch strings.ToLower(attr.Key) {
+          case "property", "name":
+            if key == "" {
+              key = strings.ToLower(strings.TrimSpace(attr.Val))
+            }
+          case "content":
+            content = strings.TrimSpace(attr.Val)
+          }
+        }
+        // The first value wins, as it does for crawlers
+        if _, seen := meta[key]; key != "" && content != "" && !seen {
+          meta[key] = content
+        }
+      case atom.Body:
+        done = true
+      }
+    case html.EndTagToken:
+      switch tokenizer.Token().DataAtom {
+      case atom.Title:
+        inTitle = false
+      case atom.Head:
+        done = true
+      }
+    case html.TextToken:
+      if inTitle {
+        title += string(tokenizer.Text())
+      }
+    }
+  }
+
+  first := func(values ...string) string {
+    for _, value := range values {
+      if value != "" {
+        return value
+      }
+    }
+    return ""
+  }
+
+  preview := Preview{
+    Url: pageUrl.String(),
+    Title: truncate(first(meta["og:title"], meta["twitter:title"], strings.TrimSpace(title)), maxTitleLength),
+    Description: truncate(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescriptionLength),
+    SiteName: truncate(meta["og:site_name"], maxTitleLength),
+  }
+  if image := first(meta["og:image:secure_url"], meta["og:image"], meta["og:image:url"], meta["twitter:image"], meta["twitter:image:src"]); image != "" {
+    if imageUrl, err := pageUrl.Parse(image); err == nil && (imageUrl.Scheme == "http" || imageUrl.Scheme == "https") {
+      preview.Image = imageUrl.String()
+    }
+  }
+  return preview, nil
+}
+
+// truncate collapses runs of whitespace and cuts s to at most max runes
+func truncate(s string, max int) string {
+  s = strings.Join(strings.Fields(s), " ")
+  if utf8.RuneCountInString(s) <= max {
+    return s
+  }
+  runes := []rune(s)
+  return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
@@ -0,0 +1,123 @@
+package previews
+
+import (
+  "io"
+  "fmt"
+  "mime"
+  "time"
+  "errors"
+  "context"
+  "net/url"
+  "net/http"
+)
+
+// Preview is what a page says about itself in its OpenGraph and
+// Twitter card metadata, falling back to its title and description
+type Preview struct {
+  // Url is the page the preview was read from, after any redirects
+  Url string
+  Title string
+  Description string
+  // Image is an absolute http or https URL, if the page has one
+  Image string
+  SiteName string
+}
+
+// ErrNoPreview is returned for pages that don't have a title
+var ErrNoPreview = errors.New("Page has no title to preview")
+
+// Doer sends HTTP requests. *http.Client is one, and NewSafeClient
+// returns one that can only reach public addresses.
+type Doer interface {
+  Do(req *http.Request) (*http.Response, error)
+}
+
+// Options controls how a Fetcher fetches pages. Zero values use the
+// defaults.
+type Options struct {
+  // Client sends the requests. It defaults to NewSafeClient, so only
+  // replace it with one that checks addresses the same way, or in
+  // tests that need to reach a local server.
+  Client Doer
+  // Timeout bounds a whole fetch, including redirects and reading the
+  // page. It defaults to DefaultTimeout.
+  Timeout time.Duration
+  // MaxBytes is how much of a page is read looking for metadata. It
+  // defaults to DefaultMaxBytes.
+  MaxBytes int64
+}
+
+const (
+  DefaultTimeout = 5 * time.Second
+  DefaultMaxBytes = 512 << 10
+)
+
+// Fetcher reads previews from web pages
+type Fetcher struct {
+  client Doer
+  timeout time.Duration
+  maxBytes int64
+}
+
+func NewFetcher(options Options) *Fetcher {
+  fetcher := &Fetcher{
+    client: options.Client,
+    timeout: options.Timeout,
+    maxBytes: options.MaxBytes,
+  }
+  if fetcher.timeout <= 0 {
+    fetcher.timeout = DefaultTimeout
+  }
+  if fetcher.maxBytes <= 0 {
+    fetcher.maxBytes = DefaultMaxBytes
+  }
+  if fetcher.client == nil {
+    fetcher.client = NewSafeClient(fetcher.timeout)
+  }
+  return fetcher
+}
+
+// Fetch reads the preview of the page at rawUrl. Only the first
+// MaxBytes of the page are read, and pages that aren't HTML fail.
+func (f *Fetcher) Fetch(ctx context.Context, rawUrl string) (Preview, error) {
+  pageUrl, err := url.Parse(rawUrl)
+  if err != nil || (pageUrl.Scheme != "http" && pageUrl.Scheme != "https") || pageUrl.Host == "" {
+    return Preview{}, fmt.Errorf("Invalid URL: %q", rawUrl)
+  }
+
+  ctx, cancel := context.WithTimeout(ctx, f.timeout)
+  defer cancel()
+
+  req, err := http.NewRequestWithContext(ctx, "GET", pageUrl.String(), nil)
+  if err != nil {
+    return Preview{}, err
+  }
+  req.Header.Set("Accept", "text/html,application/xhtml+xml")
+  req.Header.Set("User-Agent", "Chirpy link previews")
+
+  resp, err := f.client.Do(req)
+  if err != nil {
+    return Preview{}, err
+  }
+  defer resp.Body.Close()
+
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    return Preview{}, fmt.Errorf("Page responded %v", resp.Status)
+  }
+  mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+  if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+    return Preview{}, fmt.Errorf("Page isn't HTML: %q", mediaType)
+  }
+
+  if resp.Request != nil && resp.Request.URL != nil {
+    pageUrl = resp.Request.URL
+  }
+  preview, err := parse(io.LimitReader(resp.Body, f.maxBytes), pageUrl)
+  if err != nil {
+    return Preview{}, err
+  }
+  if preview.Title == "" {
+    return Preview{}, ErrNoPreview
+  }
+  return preview, nil
+}
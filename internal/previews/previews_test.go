@@ -0,0 +1,152 @@
+package previews
+
+import (
+  "time"
+  "errors"
+  "context"
+  "strings"
+  "testing"
+  "net/http"
+  "net/netip"
+  "net/http/httptest"
+)
+
+// serve starts a test server that answers every request with page as
+// HTML. Fetchers reach it through its own client, since the safe client
+// won't connect to localhost.
+func serve(t *testing.T, page string) *httptest.Server {
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Write([]byte(page))
+  }))
+  t.Cleanup(server.Close)
+  return server
+}
+
+func TestFetchOpenGraph(t *testing.T) {
+  server := serve(t, `<!doctype html>
+<html><head>
+  <title>Fallback title</title>
+  <meta property="og:title" content="  The   real title ">
+  <meta property="og:title" content="Second title">
+  <meta name="description" content="Plain description">
+  <meta property="og:description" content="OpenGraph description">
+  <meta property="og:site_name" content="Example">
+  <meta property="og:image" content="/images/cover.png">
+</head><body><meta property="og:title" content="In the body"></body></html>`)
+
+  fetcher := NewFetcher(Options{Client: server.Client()})
+  preview, err := fetcher.Fetch(context.Background(), server.URL+"/post")
+  if err != nil {
+    t.Fatalf("Fetch failed: %v", err)
+  }
+
+  expected := Preview{
+    Url: server.URL + "/post",
+    Title: "The real title",
+    Description: "OpenGraph description",
+    Image: server.URL + "/images/cover.png",
+    SiteName: "Example",
+  }
+  if preview != expected {
+    t.Errorf("Got %+v, expected %+v", preview, expected)
+  }
+}
+
+func TestFetchFallsBackToTitle(t *testing.T) {
+  server := serve(t, `<html><head><title>Just a title</title>
+<meta name="description" content="Described"></head></html>`)
+
+  fetcher := NewFetcher(Options{Client: server.Client()})
+  preview, err := fetcher.Fetch(context.Background(), server.URL)
+  if err != nil {
+    t.Fatalf("Fetch failed: %v", err)
+  }
+  if preview.Title != "Just a title" || preview.Description != "Described" {
+    t.Errorf("Got %+v", preview)
+  }
+}
+
+func TestFetchSizeCap(t *testing.T) {
+  page := `<html><head><!-- ` + strings.Repeat("padding ", 1024) + ` --><title>Too far down</title></head></html>`
+  server := serve(t, page)
+
+  fetcher := NewFetcher(Options{Client: server.Client(), MaxBytes: 1024})
+  if _, err := fetcher.Fetch(context.Background(), server.URL); !errors.Is(err, ErrNoPreview) {
+    t.Errorf("Got %v, expected ErrNoPreview for a title past MaxBytes", err)
+  }
+
+  fetcher = NewFetcher(Options{Client: server.Client(), MaxBytes: int64(len(page))})
+  if _, err := fetcher.Fetch(context.Background(), server.URL); err != nil {
+    t.Errorf("Fetch failed with the whole page in reach: %v", err)
+  }
+}
+
+func TestFetchTimeout(t *testing.T) {
+  release := make(chan struct{})
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    select {
+    case <-r.Context().Done():
+    case <-release:
+    }
+  }))
+  t.Cleanup(server.Close)
+  t.Cleanup(func() { close(release) })
+
+  fetcher := NewFetcher(Options{Client: server.Client(), Timeout: 50 * time.Millisecond})
+  start := time.Now()
+  _, err := fetcher.Fetch(context.Background(), server.URL)
+  if !errors.Is(err, context.DeadlineExceeded) {
+    t.Errorf("Got %v, expected the fetch to time out", err)
+  }
+  if elapsed := time.Since(start); elapsed > time.Second {
+    t.Errorf("Fetch took %v with a 50ms timeout", elapsed)
+  }
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "image/png")
+    w.Write([]byte("<title>Not really</title>"))
+  }))
+  t.Cleanup(server.Close)
+
+  fetcher := NewFetcher(Options{Client: server.Client()})
+  if _, err := fetcher.Fetch(context.Background(), server.URL); err == nil {
+    t.Error("Fetch of a PNG succeeded")
+  }
+}
+
+func TestSafeClientBlocksPrivateAddresses(t *testing.T) {
+  server := serve(t, `<html><head><title>Internal</title></head></html>`)
+
+  // The default client is the safe one
+  fetcher := NewFetcher(Options{Timeout: time.Second})
+  if _, err := fetcher.Fetch(context.Background(), server.URL); !errors.Is(err, ErrBlockedAddress) {
+    t.Errorf("Got %v, expected ErrBlockedAddress for %v", err, server.URL)
+  }
+}
+
+func TestPublicAddr(t *testing.T) {
+  cases := map[string]bool{
+    "8.8.8.8": true,
+    "2606:4700:4700::1111": true,
+    "127.0.0.1": false,
+    "::1": false,
+    "10.1.2.3": false,
+    "172.16.0.1": false,
+    "192.168.1.1": false,
+    "169.254.169.254": false,
+    "fe80::1": false,
+    "fc00::1": false,
+    "100.64.0.1": false,
+    "0.0.0.0": false,
+    "::ffff:127.0.0.1": false,
+    "224.0.0.1": false,
+  }
+  for addr, public := range cases {
+    if got := PublicAddr(netip.MustParseAddr(addr)); got != public {
+      t.Errorf("PublicAddr(%v) = %v, expected %v", addr, got, public)
+    }
+  }
+}
@@ -0,0 +1,105 @@
+package previews
+
+import (
+  "net"
+  "fmt"
+  "time"
+  "errors"
+  "syscall"
+  "net/http"
+  "net/netip"
+)
+
+// maxRedirects is how many redirects a safe client follows
+const maxRedirects = 5
+
+// ErrBlockedAddress is returned when a request would connect to an
+// address that isn't public, such as localhost or the internal network
+var ErrBlockedAddress = errors.New("Address isn't public")
+
+// reservedPrefixes are ranges that aren't private by netip's reckoning
+// but still don't lead anywhere a preview should come from
+var reservedPrefixes = []netip.Prefix{
+  netip.MustParsePrefix("0.0.0.0/8"),
+  netip.MustParsePrefix("100.64.0.0/10"),
+  netip.MustParsePrefix("192.0.0.0/24"),
+  netip.MustParsePrefix("192.0.2.0/24"),
+  netip.MustParsePrefix("198.18.0.0/15"),
+  netip.MustParsePrefix("198.51.100.0/24"),
+  netip.MustParsePrefix("203.0.113.0/24"),
+  netip.MustParsePrefix("240.0.0.0/4"),
+  netip.MustParsePrefix("64:ff9b::/96"),
+  netip.MustParsePrefix("64:ff9b:1::/48"),
+  netip.MustParsePrefix("2001::/23"),
+  netip.MustParsePrefix("2001:db8::/32"),
+  netip.MustParsePrefix("2002::/16"),
+}
+
+// PublicAddr reports whether addr is a public unicast address
+func PublicAddr(addr netip.Addr) bool {
+  addr = addr.Unmap()
+  if !addr.IsValid() ||
+    addr.IsLoopback() ||
+    addr.IsPrivate() ||
+    addr.IsUnspecified() ||
+    addr.IsLinkLocalUnicast() ||
+    addr.IsLinkLocalMulticast() ||
+    addr.IsInterfaceLocalMulticast() ||
+    addr.IsMulticast() {
+    return false
+  }
+  for _, prefix := range reservedPrefixes {
+    if prefix.Contains(addr) {
+      return false
+    }
+  }
+  return true
+}
+
+// checkDial refuses connections to addresses that aren't public. It's
+// called with the address a host name resolved to, so a name that
+// resolves differently from one lookup to the next can't get around it.
+func checkDial(network string, address string, _ syscall.RawConn) error {
+  host, _, err := net.SplitHostPort(address)
+  if err != nil {
+    return err
+  }
+  addr, err := netip.ParseAddr(host)
+  if err != nil || !PublicAddr(addr) {
+    return fmt.Errorf("Unable to connect to %v: %w", host, ErrBlockedAddress)
+  }
+  return nil
+}
+
+// NewSafeClient returns a client that only connects to public
+// addresses, ignores proxy settings, since those would connect on its
+// behalf, and follows a few redirects as long as they stay on http or
+// https
+func NewSafeClient(timeout time.Duration) *http.Client {
+  dialer := &net.Dialer{
+    Timeout: timeout,
+    Control: checkDial,
+  }
+  transport := &http.Transport{
+    Proxy: nil,
+    DialContext: dialer.DialContext,
+    TLSHandshakeTimeout: timeout,
+    ResponseHeaderTimeout: timeout,
+    MaxResponseHeaderBytes: 64 << 10,
+    MaxIdleConns: 10,
+    IdleConnTimeout: 30 * time.Second,
+  }
+  return &http.Client{
+    Transport: transport,
+    Timeout: timeout,
+    CheckRedirect: func(req *http.Request, via []*http.Request) error {
+      if len(via) >= maxRedirects {
+        return fmt.Errorf("Stopped after %d redirects", maxRedirects)
+      }
+      if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+        return fmt.Errorf("Redirected to unsupported scheme: %q", req.URL.Scheme)
+      }
+      return nil
+    },
+  }
+}
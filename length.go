@@ -0,0 +1,95 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "net/http"
+  "encoding/json"
+  "unicode/utf8"
+
+  "github.com/kekekekyle/database"
+  "github.com/rivo/uniseg"
+)
+
+const (
+  // lengthGraphemes counts what readers see as characters, so an emoji
+  // made of several code points counts once
+  lengthGraphemes = "graphemes"
+  // lengthRunes counts Unicode code points
+  lengthRunes = "runes"
+)
+
+// chirpLengthLimits decides how long a chirp can be
+type chirpLengthLimits struct {
+  unit string
+  max int
+  // chirpyRedMax applies instead of max for Chirpy Red members
+  chirpyRedMax int
+}
+
+func newChirpLengthLimits(unit string, max int, chirpyRedMax int) (chirpLengthLimits, error) {
+  if unit != lengthGraphemes && unit != lengthRunes {
+    return chirpLengthLimits{}, fmt.Errorf("Chirp length unit must be %v or %v, not %v", lengthGraphemes, lengthRunes, unit)
+  }
+  if max < 1 || chirpyRedMax < 1 {
+    return chirpLengthLimits{}, fmt.Errorf("Chirp length limits must be at least 1")
+  }
+  return chirpLengthLimits{unit: unit, max: max, chirpyRedMax: chirpyRedMax}, nil
+}
+
+func (l chirpLengthLimits) measure(body string) int {
+  if l.unit == lengthRunes {
+    return utf8.RuneCountInString(body)
+  }
+  return uniseg.GraphemeClusterCount(body)
+}
+
+// lengthError says which length rule a chirp broke
+type lengthError struct {
+  Error string `json:"error"`
+  Rule string `json:"rule"`
+  Unit string `json:"unit"`
+  Length int `json:"length"`
+  Max int `json:"max,omitempty"`
+}
+
+// check returns the rule body breaks for user, or nil if it's fine
+func (l chirpLengthLimits) check(user database.User, body string) *lengthError {
+  length := l.measure(body)
+  if strings.TrimSpace(body) == "" {
+    return &lengthError{Error: "Chirp is empty", Rule: "not_empty", Unit: l.unit, Length: length}
+  }
+
+  rule, max := "max_length", l.max
+  if user.IsChirpyRed {
+    rule, max = "chirpy_red_max_length", l.chirpyRedMax
+  }
+  if length > max {
+    return &lengthError{
+      Error: fmt.Sprintf("Chirp is too long: %v %v, the limit is %v", length, l.unit, max),
+      Rule: rule,
+      Unit: l.unit,
+      Length: length,
+      Max: max,
+    }
+  }
+  return nil
+}
+
+// validateChirpLength responds 400 and returns false if body is too
+// long or empty for user
+func (cfg *apiConfig) validateChirpLength(w http.ResponseWriter, user database.User, body string) bool {
+  lengthErr := cfg.chirpLength.check(user, body)
+  if lengthErr == nil {
+    return true
+  }
+
+  data, err := json.Marshal(lengthErr)
+  if err != nil {
+    w.WriteHeader(500)
+    return false
+  }
+  w.WriteHeader(400)
+  w.Write(data)
+  return false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+  "fmt"
+  "strconv"
+  "net/http"
+  "encoding/json"
+
+  "github.com/kekekekyle/database"
+)
+
+// HandleChirpInteraction serves the like and rechirp endpoints, which
+// all apply one user's action to a chirp and return the result. The
+// actions are idempotent, so repeating a request is harmless. If event
+// is set it's published with the chirp apply returns, but only when the
+// action changed something. status is sent when it did, and 200 when
+// it didn't.
+type HandleChirpInteraction struct {
+  api *apiConfig
+  apply func(chirpId int, userId int) (database.Chirp, bool, error)
+  status int
+  event string
+}
+
+func (h *HandleChirpInteraction) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirpIndex, err := strconv.Atoi(r.PathValue("chirpId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  if _, err := h.api.database.FindChirpById(chirpIndex); err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  chirp, changed, err := h.apply(chirpIndex, user.Id)
+  if err != nil {
+    w.WriteHeader(404)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  if changed && h.event != "" {
+    h.api.events.Publish(Event{Type: h.event, ActorId: user.Id, Chirp: chirp})
+  }
+
+  data, err := json.Marshal(chirp)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+
+  if changed {
+    w.WriteHeader(h.status)
+  } else {
+    w.WriteHeader(200)
+  }
+  w.Write(data)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+  "fmt"
+  "testing"
+)
+
+func TestRechirpStatus(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("author@example.com")
+  _, otherToken := api.signUp("other@example.com")
+
+  _, chirp := api.do("POST", "/api/chirps", token, map[string]string{"body": "Worth sharing"})
+  path := fmt.Sprintf("/api/chirps/%d/rechirps", int(chirp["id"].(float64)))
+
+  status, rechirp := api.do("POST", path, otherToken, nil)
+  if status != 201 {
+    t.Fatalf("Rechirp responded %v", status)
+  }
+  status, repeat := api.do("POST", path, otherToken, nil)
+  if status != 200 {
+    t.Errorf("Repeat rechirp responded %v, expected 200", status)
+  }
+  if repeat["id"] != rechirp["id"] {
+    t.Errorf("Repeat rechirp returned %v, expected %v", repeat["id"], rechirp["id"])
+  }
+}
+
+func TestGetRechirpOfDeletedChirp(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("author@example.com")
+  _, otherToken := api.signUp("other@example.com")
+
+  _, chirp := api.do("POST", "/api/chirps", token, map[string]string{"body": "Soon gone"})
+  chirpPath := fmt.Sprintf("/api/chirps/%d", int(chirp["id"].(float64)))
+  _, rechirp := api.do("POST", chirpPath+"/rechirps", otherToken, nil)
+  rechirpPath := fmt.Sprintf("/api/chirps/%d", int(rechirp["id"].(float64)))
+
+  if status, _ := api.do("GET", rechirpPath, "", nil); status != 200 {
+    t.Fatalf("Get of the rechirp responded %v", status)
+  }
+  if status, _ := api.do("DELETE", chirpPath, token, nil); status != 204 {
+    t.Fatalf("Delete responded %v", status)
+  }
+  if status, _ := api.do("GET", rechirpPath, "", nil); status != 404 {
+    t.Errorf("Get of a rechirp of a deleted chirp responded %v", status)
+  }
+}
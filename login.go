@@ -98,16 +98,22 @@ func (cfg *apiConfig) handleLogin (w http.ResponseWriter, r *http.Request) {
     return
   }
 
-  createdRefreshToken, err := cfg.database.CreateRefreshToken(user, refreshToken)
-  if err != nil {
-    w.WriteHeader(500)
-    w.Write([]byte(fmt.Sprintf("%v", err)))
-    return
-  }
-
-  foundUser.RefreshToken = createdRefreshToken
-
-  updateUser, err := cfg.database.UpdateUser(foundUser)
+  // Store the refresh token and the new expiry together so a failed
+  // write can't leave the user with only half of the login applied
+  var updateUser database.User
+  err = cfg.database.Update(func(dbStructure *database.DBStructure) error {
+    storedUser, ok := dbStructure.Users[foundUser.Id]
+    if !ok {
+      return fmt.Errorf("No user found with id: %v", foundUser.Id)
+    }
+    storedUser.ExpiresInSeconds = foundUser.ExpiresInSeconds
+    storedUser.RefreshToken = refreshToken
+    dbStructure.PutUser(storedUser)
+
+    updateUser = storedUser
+    updateUser.Token = foundUser.Token
+    return nil
+  })
   if err != nil {
     w.WriteHeader(500)
     w.Write([]byte(fmt.Sprintf("%v", err)))
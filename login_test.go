@@ -0,0 +1,41 @@
+package main
+
+import (
+  "testing"
+)
+
+func TestLogin(t *testing.T) {
+  api := newTestAPI(t)
+  userId, _ := api.signUp("user@example.com")
+
+  status, user := api.do("POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "hunter2"})
+  if status != 200 {
+    t.Fatalf("Login responded %v", status)
+  }
+  if int(user["id"].(float64)) != userId || user["email"] != "user@example.com" {
+    t.Errorf("Logged in as %v", user)
+  }
+  if user["token"] == "" || user["refresh_token"] == "" {
+    t.Errorf("Login returned no tokens: %v", user)
+  }
+  if _, ok := user["password"]; ok {
+    t.Error("Login returned the password hash")
+  }
+
+  // The token works on authenticated endpoints
+  if status, _ := api.do("POST", "/api/chirps", user["token"].(string), map[string]string{"body": "Logged in"}); status != 201 {
+    t.Errorf("Chirping with the login token responded %v", status)
+  }
+}
+
+func TestLoginFailures(t *testing.T) {
+  api := newTestAPI(t)
+  api.signUp("user@example.com")
+
+  if status, _ := api.do("POST", "/api/login", "", map[string]string{"email": "user@example.com", "password": "wrong"}); status != 401 {
+    t.Errorf("Login with the wrong password responded %v", status)
+  }
+  if status, _ := api.do("POST", "/api/login", "", map[string]string{"email": "nobody@example.com", "password": "hunter2"}); status == 200 {
+    t.Error("Login as a missing user succeeded")
+  }
+}
@@ -5,16 +5,30 @@ import (
   "os"
   "flag"
 	"log"
+  "time"
+  "context"
+  "syscall"
+  "os/signal"
 	"net/http"
+  "github.com/kekekekyle/blobs"
   "github.com/kekekekyle/database"
+  "github.com/kekekekyle/moderation"
+  "github.com/kekekekyle/previews"
   "github.com/joho/godotenv"
 )
 
 type apiConfig struct {
   fileserverHits int
-  database *database.DB
+  database database.Store
   jwtSecret string
   polkaApiKey string
+  adminEmails map[string]bool
+  snapshotDir string
+  snapshotRetention database.SnapshotRetention
+  moderation *moderation.Chain
+  chirpLength chirpLengthLimits
+  events *eventBus
+  media mediaConfig
 }
 
 func (cfg *apiConfig) middlewareMetricsInc (next http.Handler) http.Handler {
@@ -46,32 +60,183 @@ func handleHealth (w http.ResponseWriter, r *http.Request) {
   w.Write([]byte("OK"))
 }
 
-// deleteDB deletes the database file
+// deleteDB deletes the database file and its journal
 func deleteDB(path string) error {
   if err := os.Remove(path); err != nil {
     return err
   }
+  if err := os.Remove(database.JournalPath(path)); err != nil && !os.IsNotExist(err) {
+    return err
+  }
   return nil
 }
 
+// printMigrations lists the migrations a dry run would apply
+func printMigrations(report database.MigrationReport) {
+  if len(report.Applied) == 0 {
+    fmt.Printf("Database is up to date at schema version %d\n", report.From)
+    return
+  }
+  fmt.Printf("Database would be migrated from version %d to %d:\n", report.From, report.To)
+  for _, migration := range report.Applied {
+    fmt.Printf("  %d: %s\n", migration.Version, migration.Description)
+  }
+}
+
+// routes registers every endpoint on a new mux, serving static files
+// from filepathRoot
+func (cfg *apiConfig) routes(filepathRoot string) *http.ServeMux {
+  mux := http.NewServeMux()
+  handleFiles := http.StripPrefix("/app/", http.FileServer(http.Dir(filepathRoot)))
+  mux.Handle("/app/", cfg.middlewareMetricsInc(handleFiles))
+  mux.Handle("GET "+mediaPath, http.StripPrefix(mediaPath, cfg.serveMedia()))
+  mux.HandleFunc("GET /api/healthz", handleHealth)
+  mux.HandleFunc("GET /admin/metrics", cfg.getMetricsHandler)
+  mux.Handle("POST /admin/backup", cfg.authenticateAdmin(http.HandlerFunc(cfg.handleAdminBackup)))
+  mux.Handle("GET /admin/moderation/queue", cfg.authenticateAdmin(http.HandlerFunc(cfg.handleModerationQueue)))
+  mux.Handle("POST /admin/moderation/chirps/{chirpId}", cfg.authenticateAdmin(http.HandlerFunc(cfg.handleModerateChirp)))
+  mux.Handle("GET /admin/moderation/log", cfg.authenticateAdmin(http.HandlerFunc(cfg.handleModerationLog)))
+  mux.HandleFunc("/api/reset", cfg.resetMetricsHandler)
+  mux.Handle("POST /api/chirps", cfg.authenticate(&HandleCreateChirps{api: cfg}))
+  mux.HandleFunc("GET /api/chirps", cfg.handleGetChirps)
+  mux.HandleFunc("GET /api/chirps/search", cfg.handleSearchChirps)
+  mux.HandleFunc("GET /api/chirps/{chirpId}", cfg.handleGetChirpById)
+  mux.Handle("DELETE /api/chirps/{chirpId}", cfg.authenticate(&HandleDeleteChirps{api: cfg}))
+  mux.Handle("PATCH /api/chirps/{chirpId}", cfg.authenticate(&HandleEditChirps{api: cfg}))
+  mux.HandleFunc("GET /api/chirps/{chirpId}/revisions", cfg.handleGetChirpRevisions)
+  mux.HandleFunc("GET /api/chirps/{chirpId}/thread", cfg.handleGetChirpThread)
+  mux.Handle("POST /api/chirps/{chirpId}/undelete", cfg.authenticate(&HandleUndeleteChirps{api: cfg}))
+  mux.Handle("POST /api/chirps/{chirpId}/reports", cfg.authenticate(&HandleReportChirps{api: cfg}))
+  mux.Handle("POST /api/chirps/{chirpId}/likes", cfg.authenticate(&HandleChirpInteraction{api: cfg, apply: cfg.database.LikeChirp, status: 200, event: EventChirpLiked}))
+  mux.Handle("DELETE /api/chirps/{chirpId}/likes", cfg.authenticate(&HandleChirpInteraction{api: cfg, apply: cfg.database.UnlikeChirp, status: 200}))
+  mux.Handle("POST /api/chirps/{chirpId}/rechirps", cfg.authenticate(&HandleChirpInteraction{api: cfg, apply: cfg.database.Rechirp, status: 201, event: EventChirpRechirped}))
+  mux.Handle("DELETE /api/chirps/{chirpId}/rechirps", cfg.authenticate(&HandleChirpInteraction{api: cfg, apply: cfg.database.Unrechirp, status: 200}))
+  mux.HandleFunc("POST /api/users", cfg.handleCreateUser)
+  mux.HandleFunc("PUT /api/users", cfg.handleUpdateUser)
+  mux.Handle("POST /api/users/{userId}/follow", cfg.authenticate(&HandleFollowUsers{api: cfg}))
+  mux.Handle("DELETE /api/users/{userId}/follow", cfg.authenticate(&HandleUnfollowUsers{api: cfg}))
+  mux.HandleFunc("GET /api/users/{userId}/followers", cfg.handleFollowList(cfg.database.ListFollowers))
+  mux.HandleFunc("GET /api/users/{userId}/following", cfg.handleFollowList(cfg.database.ListFollowing))
+  mux.HandleFunc("GET /api/users/{userId}/mentions", cfg.handleGetUserMentions)
+  mux.HandleFunc("GET /api/hashtags/{tag}/chirps", cfg.handleGetHashtagChirps)
+  mux.Handle("GET /api/timeline", cfg.authenticate(&HandleTimeline{api: cfg}))
+  mux.Handle("POST /api/conversations", cfg.authenticate(&HandleConversations{api: cfg}))
+  mux.Handle("GET /api/conversations", cfg.authenticate(&HandleListConversations{api: cfg}))
+  mux.Handle("GET /api/conversations/{conversationId}", cfg.authenticate(&HandleGetConversation{api: cfg}))
+  mux.Handle("POST /api/conversations/{conversationId}/messages", cfg.authenticate(&HandleSendMessages{api: cfg}))
+  mux.Handle("GET /api/conversations/{conversationId}/messages", cfg.authenticate(&HandleListMessages{api: cfg}))
+  mux.Handle("POST /api/conversations/{conversationId}/read", cfg.authenticate(&HandleReadConversations{api: cfg}))
+  mux.Handle("GET /api/notifications", cfg.authenticate(&HandleListNotifications{api: cfg}))
+  mux.Handle("POST /api/notifications/read", cfg.authenticate(&HandleReadAllNotifications{api: cfg}))
+  mux.Handle("POST /api/notifications/{notificationId}/read", cfg.authenticate(&HandleReadNotifications{api: cfg}))
+  mux.HandleFunc("POST /api/login", cfg.handleLogin)
+  mux.HandleFunc("POST /api/refresh", cfg.handleRefreshToken)
+  mux.HandleFunc("POST /api/revoke", cfg.handleRevokeToken)
+  mux.HandleFunc("POST /api/polka/webhooks", cfg.handlePolkaWebhooks)
+  return mux
+}
+
 func main() {
   const filepathRoot = "app"
-  const databasePath = "database.json"
 	const port = "42069"
 
   godotenv.Load()
   jwtSecret := os.Getenv("JWT_SECRET")
   polkaApiKey := os.Getenv("POLKA_API_KEY")
+  adminEmails := os.Getenv("ADMIN_EMAILS")
 
   debug := flag.Bool("debug", false, "Enable debug mode")
+  backend := flag.String("store", database.BackendJSON, "Database backend: json, sqlite or memory")
+  databasePath := flag.String("db", "", "Path to the database file (defaults to database.json or database.db)")
+  flushInterval := flag.Duration("flush-interval", 0, "How often to write pending changes to disk (0 writes every change through)")
+  flushBatch := flag.Int("flush-batch", 0, "Write to disk early once this many changes are pending (0 disables)")
+  timeOrderedIds := flag.Bool("time-ordered-ids", false, "Give chirps ids that sort by creation time")
+  migrateDryRun := flag.Bool("migrate-dry-run", false, "Report the migrations the database needs and exit without changing it")
+  snapshotDir := flag.String("snapshot-dir", "snapshots", "Directory for database snapshots")
+  snapshotKeep := flag.Int("snapshot-keep", 10, "Number of snapshots to keep (0 keeps all)")
+  snapshotMaxAge := flag.Duration("snapshot-max-age", 0, "Delete snapshots older than this (0 keeps any age)")
+  purgeInterval := flag.Duration("purge-interval", time.Hour, "How often to purge deleted chirps")
+  tombstoneRetention := flag.Duration("tombstone-retention", 30*24*time.Hour, "How long deleted chirps can be undeleted before they are purged")
+  moderationWords := flag.String("moderation-words", "moderation/words.txt", "Word list for chirp moderation, reloaded when it changes")
+  moderationRules := flag.String("moderation-rules", "moderation/rules.json", "Regular expression rules for chirp moderation, reloaded when they change (empty disables)")
+  chirpLengthUnit := flag.String("chirp-length-unit", lengthGraphemes, "How chirp length is counted: graphemes or runes")
+  chirpMaxLength := flag.Int("chirp-max-length", 140, "Longest chirp allowed")
+  chirpyRedMaxLength := flag.Int("chirpy-red-max-length", 280, "Longest chirp allowed for Chirpy Red members")
+  mediaDir := flag.String("media-dir", "media", "Directory chirp attachments are stored in")
+  mediaMaxSize := flag.Int64("media-max-size", 5<<20, "Largest attachment allowed, in bytes")
+  mediaMaxAttachments := flag.Int("media-max-attachments", 4, "Most attachments a chirp can have")
+  mediaMaxDimension := flag.Int("media-max-dimension", 4096, "Widest or tallest an attached image can be, in pixels")
+  previewWorkers := flag.Int("preview-workers", 2, "How many link previews to fetch at once (0 disables link previews)")
+  previewTimeout := flag.Duration("preview-timeout", previews.DefaultTimeout, "How long fetching a link preview can take")
+  previewMaxSize := flag.Int64("preview-max-size", previews.DefaultMaxBytes, "How much of a page to read looking for a link preview, in bytes")
+  previewTTL := flag.Duration("preview-ttl", 24*time.Hour, "How long a link preview is cached before it's fetched again")
+  logNotifications := flag.Bool("log-notifications", false, "Also deliver notifications to the server log")
+  seed := flag.String("seed", "", "Reset the database to this fixture file before serving")
+  flag.Usage = func() {
+    fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [backup | restore [snapshot] | seed <fixture>]\n", os.Args[0])
+    flag.PrintDefaults()
+  }
   flag.Parse()
+
+  if *databasePath == "" {
+    *databasePath = "database.json"
+    if *backend == database.BackendSQLite {
+      *databasePath = "database.db"
+    }
+  }
+
   if *debug == true {
-    deleteDB(databasePath)
+    deleteDB(*databasePath)
+  }
+
+  snapshotRetention := database.SnapshotRetention{
+    Keep: *snapshotKeep,
+    MaxAge: *snapshotMaxAge,
+  }
+
+  database, err := database.Open(*backend, *databasePath, database.Options{
+    FlushInterval: *flushInterval,
+    MaxPendingWrites: *flushBatch,
+    TimeOrderedChirpIds: *timeOrderedIds,
+    MigrationDryRun: *migrateDryRun,
+  })
+  if err != nil {
+    log.Fatalf("Unable to create database: %v", err)
+  }
+
+  if *migrateDryRun {
+    printMigrations(database.Migrations())
+    database.Close()
+    return
+  }
+
+  moderationChain, moderationWatchers, err := loadModeration(*moderationWords, *moderationRules)
+  if err != nil {
+    log.Fatalf("Unable to load moderation rules: %v", err)
+  }
+
+  chirpLength, err := newChirpLengthLimits(*chirpLengthUnit, *chirpMaxLength, *chirpyRedMaxLength)
+  if err != nil {
+    log.Fatal(err)
   }
 
-  database, err := database.NewDB(databasePath)
+  mediaStore, err := blobs.NewDisk(*mediaDir)
   if err != nil {
-    fmt.Println("Unable to create database.")
+    log.Fatalf("Unable to create media store: %v", err)
+  }
+
+  events := newEventBus()
+  deliveries := []notificationDelivery{}
+  if *logNotifications {
+    deliveries = append(deliveries, logDelivery{})
+  }
+  newNotifier(database, deliveries...).subscribe(events)
+
+  var previewer *linkPreviewer
+  if *previewWorkers > 0 {
+    fetcher := previews.NewFetcher(previews.Options{Timeout: *previewTimeout, MaxBytes: *previewMaxSize})
+    previewer = newLinkPreviewer(database, fetcher, *previewTTL, 100)
+    previewer.subscribe(events)
   }
 
   apiCfg := &apiConfig {
@@ -79,30 +244,72 @@ func main() {
     database: database,
     jwtSecret: jwtSecret,
     polkaApiKey: polkaApiKey,
+    adminEmails: parseAdminEmails(adminEmails),
+    snapshotDir: *snapshotDir,
+    snapshotRetention: snapshotRetention,
+    moderation: moderationChain,
+    chirpLength: chirpLength,
+    events: events,
+    media: mediaConfig{
+      store: mediaStore,
+      maxBytes: *mediaMaxSize,
+      maxAttachments: *mediaMaxAttachments,
+      maxDimension: *mediaMaxDimension,
+    },
   }
 
-	mux := http.NewServeMux()
-  handleFiles := http.StripPrefix("/app/", http.FileServer(http.Dir(filepathRoot)))
-  mux.Handle("/app/", apiCfg.middlewareMetricsInc(handleFiles))
-  mux.HandleFunc("GET /api/healthz", handleHealth)
-  mux.HandleFunc("GET /admin/metrics", apiCfg.getMetricsHandler)
-  mux.HandleFunc("/api/reset", apiCfg.resetMetricsHandler)
-  mux.Handle("POST /api/chirps", apiCfg.authenticate(&HandleCreateChirps{api: apiCfg}))
-  mux.HandleFunc("GET /api/chirps", apiCfg.handleGetChirps)
-  mux.HandleFunc("GET /api/chirps/{chirpId}", apiCfg.handleGetChirpById)
-  mux.Handle("DELETE /api/chirps/{chirpId}", apiCfg.authenticate(&HandleDeleteChirps{api: apiCfg}))
-  mux.HandleFunc("POST /api/users", apiCfg.handleCreateUser)
-  mux.HandleFunc("PUT /api/users", apiCfg.handleUpdateUser)
-  mux.HandleFunc("POST /api/login", apiCfg.handleLogin)
-  mux.HandleFunc("POST /api/refresh", apiCfg.handleRefreshToken)
-  mux.HandleFunc("POST /api/revoke", apiCfg.handleRevokeToken)
-  mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlePolkaWebhooks)
+  if flag.NArg() > 0 {
+    err := apiCfg.runCommand(flag.Args())
+    if closeErr := database.Close(); err == nil {
+      err = closeErr
+    }
+    if err != nil {
+      log.Fatal(err)
+    }
+    return
+  }
+
+  if *seed != "" {
+    if err := apiCfg.seedFromFile(*seed); err != nil {
+      log.Fatalf("Unable to seed database: %v", err)
+    }
+  }
+
+	mux := apiCfg.routes(filepathRoot)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: mux,
 	}
 
-	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
-	log.Fatal(srv.ListenAndServe())
+  go func() {
+    log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
+    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+      log.Fatal(err)
+    }
+  }()
+
+  stopWorkers := make(chan struct{})
+  go apiCfg.purgeDeletedChirps(*purgeInterval, *tombstoneRetention, stopWorkers)
+  for _, watcher := range moderationWatchers {
+    go watcher.Watch(stopWorkers)
+  }
+  if previewer != nil {
+    previewer.run(*previewWorkers, stopWorkers)
+  }
+
+  // Stop taking requests and flush the database before exiting
+  stop := make(chan os.Signal, 1)
+  signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+  <-stop
+  close(stopWorkers)
+
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+  if err := srv.Shutdown(ctx); err != nil {
+    log.Printf("Unable to shut down cleanly: %v", err)
+  }
+  if err := database.Close(); err != nil {
+    log.Fatalf("Unable to flush database: %v", err)
+  }
 }
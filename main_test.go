@@ -0,0 +1,143 @@
+package main
+
+import (
+  "io"
+  "bytes"
+  "testing"
+  "net/http"
+  "encoding/json"
+  "net/http/httptest"
+
+  "github.com/kekekekyle/blobs"
+  "github.com/kekekekyle/database"
+  "github.com/kekekekyle/moderation"
+  "golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+  // Hashing at full cost makes the tests crawl under -race
+  passwordCost = bcrypt.MinCost
+}
+
+// testAPI is a server backed by an in-memory database
+type testAPI struct {
+  t *testing.T
+  cfg *apiConfig
+  server *httptest.Server
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+  t.Helper()
+  db, err := database.Open(database.BackendMemory, "", database.Options{})
+  if err != nil {
+    t.Fatalf("Unable to open database: %v", err)
+  }
+  t.Cleanup(func() { db.Close() })
+
+  store, err := blobs.NewDisk(t.TempDir())
+  if err != nil {
+    t.Fatalf("Unable to create media store: %v", err)
+  }
+  chirpLength, err := newChirpLengthLimits(lengthGraphemes, 140, 280)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  events := newEventBus()
+  newNotifier(db).subscribe(events)
+  cfg := &apiConfig{
+    database: db,
+    jwtSecret: "test secret",
+    polkaApiKey: "test key",
+    adminEmails: map[string]bool{},
+    snapshotDir: t.TempDir(),
+    moderation: moderation.NewChain(),
+    chirpLength: chirpLength,
+    events: events,
+    media: mediaConfig{store: store, maxBytes: 1 << 20, maxAttachments: 4, maxDimension: 1024},
+  }
+
+  server := httptest.NewServer(cfg.routes(t.TempDir()))
+  t.Cleanup(server.Close)
+  return &testAPI{t: t, cfg: cfg, server: server}
+}
+
+// do sends body as JSON, with token as the bearer token if it's set,
+// and returns the status and the decoded response
+func (api *testAPI) do(method string, path string, token string, body interface{}) (int, map[string]interface{}) {
+  api.t.Helper()
+  authorization := ""
+  if token != "" {
+    authorization = "Bearer " + token
+  }
+  return api.doAuthorized(method, path, authorization, body)
+}
+
+// doAuthorized is do with the whole Authorization header given. It
+// reports failures with Errorf rather than Fatal so it can be called
+// from other goroutines.
+func (api *testAPI) doAuthorized(method string, path string, authorization string, body interface{}) (int, map[string]interface{}) {
+  decoded := map[string]interface{}{}
+  status, data := api.send(method, path, authorization, body)
+  json.Unmarshal(data, &decoded)
+  return status, decoded
+}
+
+// list gets a path that responds with an array
+func (api *testAPI) list(path string) []map[string]interface{} {
+  decoded := []map[string]interface{}{}
+  status, data := api.send("GET", path, "", nil)
+  if err := json.Unmarshal(data, &decoded); status != 200 || err != nil {
+    api.t.Errorf("GET %v responded %v: %s", path, status, data)
+  }
+  return decoded
+}
+
+// send makes a request and returns the status and body, or a status
+// of 0 if the request couldn't be made
+func (api *testAPI) send(method string, path string, authorization string, body interface{}) (int, []byte) {
+  var reader io.Reader
+  if body != nil {
+    data, err := json.Marshal(body)
+    if err != nil {
+      api.t.Errorf("Unable to encode %v: %v", body, err)
+      return 0, nil
+    }
+    reader = bytes.NewReader(data)
+  }
+  req, err := http.NewRequest(method, api.server.URL+path, reader)
+  if err != nil {
+    api.t.Errorf("Unable to make request %v %v: %v", method, path, err)
+    return 0, nil
+  }
+  if authorization != "" {
+    req.Header.Set("Authorization", authorization)
+  }
+
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    api.t.Errorf("%v %v failed: %v", method, path, err)
+    return 0, nil
+  }
+  defer resp.Body.Close()
+
+  data, err := io.ReadAll(resp.Body)
+  if err != nil {
+    api.t.Errorf("Unable to read response to %v %v: %v", method, path, err)
+  }
+  return resp.StatusCode, data
+}
+
+// signUp creates a user and logs them in, returning their id and token
+func (api *testAPI) signUp(email string) (int, string) {
+  api.t.Helper()
+  credentials := map[string]string{"email": email, "password": "hunter2"}
+  if status, _ := api.do("POST", "/api/users", "", credentials); status != 201 {
+    api.t.Fatalf("Creating %v responded %v", email, status)
+  }
+  status, user := api.do("POST", "/api/login", "", credentials)
+  if status != 200 {
+    api.t.Fatalf("Logging in as %v responded %v", email, status)
+  }
+  return int(user["id"].(float64)), user["token"].(string)
+}
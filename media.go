@@ -0,0 +1,214 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "log"
+  "bytes"
+  "image"
+  _ "image/gif"
+  _ "image/jpeg"
+  _ "image/png"
+  "net/http"
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "mime/multipart"
+
+  "github.com/kekekekyle/blobs"
+  "github.com/kekekekyle/database"
+)
+
+// mediaPath is where attachments are served from
+const mediaPath = "/media/"
+
+// mediaTypes maps the image types chirps can attach, as sniffed from
+// their content, to the extension their blobs are stored with and the
+// image package format that has to decode them
+var mediaTypes = map[string]struct{ extension, format string }{
+  "image/png": {".png", "png"},
+  "image/jpeg": {".jpg", "jpeg"},
+  "image/gif": {".gif", "gif"},
+}
+
+// mediaConfig is where attachments are stored and the limits on them
+type mediaConfig struct {
+  store blobs.Store
+  maxBytes int64
+  maxAttachments int
+  maxDimension int
+}
+
+// upload is an image from a request, checked and ready to store
+type upload struct {
+  name string
+  data []byte
+  contentType string
+  width int
+  height int
+}
+
+// mediaError is the response for an attachment that was turned down
+type mediaError struct {
+  Error string `json:"error"`
+  File string `json:"file,omitempty"`
+  status int
+}
+
+func (e *mediaError) write(w http.ResponseWriter) {
+  data, err := json.Marshal(e)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.WriteHeader(e.status)
+  w.Write(data)
+}
+
+// maxRequestBytes is the largest chirp request worth reading: every
+// attachment at the size limit plus room for the other fields
+func (m mediaConfig) maxRequestBytes() int64 {
+  return int64(m.maxAttachments)*m.maxBytes + 1<<20
+}
+
+// readUploads reads and checks the files in a multipart form's
+// attachments field. The content decides an upload's type, not the
+// name or the type the client sent.
+func (m mediaConfig) readUploads(form *multipart.Form) ([]upload, *mediaError) {
+  files := form.File["attachments"]
+  if len(files) > m.maxAttachments {
+    return nil, &mediaError{
+      Error: fmt.Sprintf("A chirp can have at most %d attachments", m.maxAttachments),
+      status: 400,
+    }
+  }
+
+  uploads := []upload{}
+  for _, header := range files {
+    if header.Size > m.maxBytes {
+      return nil, &mediaError{
+        Error: fmt.Sprintf("Attachment is too large: %d bytes, the limit is %d", header.Size, m.maxBytes),
+        File: header.Filename,
+        status: 413,
+      }
+    }
+    file, err := header.Open()
+    if err != nil {
+      return nil, &mediaError{Error: "Unable to read attachment", File: header.Filename, status: 400}
+    }
+    data, err := io.ReadAll(io.LimitReader(file, m.maxBytes+1))
+    file.Close()
+    if err != nil {
+      return nil, &mediaError{Error: "Unable to read attachment", File: header.Filename, status: 400}
+    }
+
+    next, mediaErr := m.checkUpload(header.Filename, data)
+    if mediaErr != nil {
+      return nil, mediaErr
+    }
+    uploads = append(uploads, next)
+  }
+  return uploads, nil
+}
+
+// checkUpload sniffs an attachment's type and checks it decodes as
+// that type within the size and dimension limits. Only the header is
+// decoded, so a huge image can't use up memory before it's rejected.
+func (m mediaConfig) checkUpload(name string, data []byte) (upload, *mediaError) {
+  if int64(len(data)) > m.maxBytes {
+    return upload{}, &mediaError{
+      Error: fmt.Sprintf("Attachment is too large, the limit is %d bytes", m.maxBytes),
+      File: name,
+      status: 413,
+    }
+  }
+
+  contentType := http.DetectContentType(data)
+  mediaType, ok := mediaTypes[contentType]
+  if !ok {
+    return upload{}, &mediaError{
+      Error: fmt.Sprintf("Attachments have to be PNG, JPEG or GIF images, not %s", contentType),
+      File: name,
+      status: 415,
+    }
+  }
+
+  config, format, err := image.DecodeConfig(bytes.NewReader(data))
+  if err != nil || format != mediaType.format {
+    return upload{}, &mediaError{Error: "Attachment isn't a valid image", File: name, status: 400}
+  }
+  if config.Width < 1 || config.Height < 1 || config.Width > m.maxDimension || config.Height > m.maxDimension {
+    return upload{}, &mediaError{
+      Error: fmt.Sprintf(
+        "Attachment is %dx%d pixels, images can be at most %dx%d",
+        config.Width, config.Height, m.maxDimension, m.maxDimension,
+      ),
+      File: name,
+      status: 400,
+    }
+  }
+
+  return upload{
+    name: name,
+    data: data,
+    contentType: contentType,
+    width: config.Width,
+    height: config.Height,
+  }, nil
+}
+
+// storeUploads puts each upload in the blob store under a random key
+// and returns their attachments. If one fails the ones already stored
+// are deleted again.
+func (m mediaConfig) storeUploads(uploads []upload) ([]database.Attachment, error) {
+  attachments := []database.Attachment{}
+  for _, next := range uploads {
+    random := make([]byte, 16)
+    if _, err := rand.Read(random); err != nil {
+      m.deleteAttachments(attachments)
+      return nil, err
+    }
+    key := hex.EncodeToString(random) + mediaTypes[next.contentType].extension
+
+    if err := m.store.Put(key, bytes.NewReader(next.data), next.contentType); err != nil {
+      m.deleteAttachments(attachments)
+      return nil, fmt.Errorf("Unable to store attachment %s: %v", next.name, err)
+    }
+    attachments = append(attachments, database.Attachment{
+      Key: key,
+      Url: mediaPath + key,
+      ContentType: next.contentType,
+      Size: int64(len(next.data)),
+      Width: next.width,
+      Height: next.height,
+    })
+  }
+  return attachments, nil
+}
+
+// deleteAttachments removes attachments' blobs, logging any it can't
+func (m mediaConfig) deleteAttachments(attachments []database.Attachment) {
+  for _, attachment := range attachments {
+    if err := m.store.Delete(attachment.Key); err != nil {
+      log.Printf("Unable to delete attachment %s: %v", attachment.Key, err)
+    }
+  }
+}
+
+// serveMedia serves the blobs attached to listed chirps from the store.
+// Those of deleted or hidden chirps are kept until the chirp is purged
+// but aren't served meanwhile, so caches only keep a blob for an hour.
+func (cfg *apiConfig) serveMedia() http.Handler {
+  files := http.FileServer(http.FS(cfg.media.store))
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    chirp, err := cfg.database.FindChirpByAttachment(r.URL.Path)
+    if err != nil || !chirp.IsListed() {
+      w.WriteHeader(404)
+      return
+    }
+
+    w.Header().Set("Cache-Control", "public, max-age=3600")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    files.ServeHTTP(w, r)
+  })
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+  "fmt"
+  "bytes"
+  "image"
+  "testing"
+  "net/http"
+  "image/png"
+  "encoding/json"
+  "mime/multipart"
+)
+
+// postChirpWithImage creates a chirp with a small PNG attached and
+// returns the decoded chirp
+func (api *testAPI) postChirpWithImage(token string, body string) map[string]interface{} {
+  api.t.Helper()
+  var form bytes.Buffer
+  writer := multipart.NewWriter(&form)
+  writer.WriteField("body", body)
+  file, err := writer.CreateFormFile("attachments", "pixel.png")
+  if err != nil {
+    api.t.Fatal(err)
+  }
+  if err := png.Encode(file, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+    api.t.Fatal(err)
+  }
+  writer.Close()
+
+  req, err := http.NewRequest("POST", api.server.URL+"/api/chirps", &form)
+  if err != nil {
+    api.t.Fatal(err)
+  }
+  req.Header.Set("Authorization", "Bearer "+token)
+  req.Header.Set("Content-Type", writer.FormDataContentType())
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    api.t.Fatal(err)
+  }
+  defer resp.Body.Close()
+
+  chirp := map[string]interface{}{}
+  if err := json.NewDecoder(resp.Body).Decode(&chirp); resp.StatusCode != 201 || err != nil {
+    api.t.Fatalf("Create with an image responded %v: %v", resp.StatusCode, err)
+  }
+  return chirp
+}
+
+func TestMediaOfUnlistedChirps(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("author@example.com")
+  _, adminToken := api.signUp("admin@example.com")
+  api.cfg.adminEmails["admin@example.com"] = true
+
+  deleted := api.postChirpWithImage(token, "Deleted soon")
+  hidden := api.postChirpWithImage(token, "Hidden soon")
+  urlOf := func(chirp map[string]interface{}) string {
+    return chirp["attachments"].([]interface{})[0].(map[string]interface{})["url"].(string)
+  }
+
+  for _, chirp := range []map[string]interface{}{deleted, hidden} {
+    if status, _ := api.send("GET", urlOf(chirp), "", nil); status != 200 {
+      t.Fatalf("Get of an attachment responded %v", status)
+    }
+  }
+
+  if status, _ := api.do("DELETE", fmt.Sprintf("/api/chirps/%d", int(deleted["id"].(float64))), token, nil); status != 204 {
+    t.Fatalf("Delete responded %v", status)
+  }
+  hidePath := fmt.Sprintf("/admin/moderation/chirps/%d", int(hidden["id"].(float64)))
+  if status, _ := api.do("POST", hidePath, adminToken, map[string]string{"action": "hide"}); status != 201 {
+    t.Fatalf("Hide responded %v", status)
+  }
+
+  for _, chirp := range []map[string]interface{}{deleted, hidden} {
+    if status, _ := api.send("GET", urlOf(chirp), "", nil); status != 404 {
+      t.Errorf("Get of an attachment of an unlisted chirp responded %v", status)
+    }
+  }
+  if status, _ := api.send("GET", "/media/", "", nil); status != 404 {
+    t.Errorf("Get of the media directory responded %v", status)
+  }
+  if status, _ := api.send("GET", "/media/missing.png", "", nil); status != 404 {
+    t.Errorf("Get of a missing attachment responded %v", status)
+  }
+
+  // Undeleting brings the attachment back
+  undeletePath := fmt.Sprintf("/api/chirps/%d/undelete", int(deleted["id"].(float64)))
+  if status, _ := api.do("POST", undeletePath, token, nil); status != 200 {
+    t.Fatalf("Undelete responded %v", status)
+  }
+  if status, _ := api.send("GET", urlOf(deleted), "", nil); status != 200 {
+    t.Errorf("Get of an attachment of an undeleted chirp responded %v", status)
+  }
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+  "log"
+  "net/http"
+  "encoding/json"
+
+  "github.com/kekekekyle/database"
+  "github.com/kekekekyle/moderation"
+)
+
+// watcher reloads a moderation file when it changes
+type watcher interface {
+  Watch(stop <-chan struct{})
+}
+
+// loadModeration builds the moderation chain from a word list and an
+// optional file of regular expression rules
+func loadModeration(wordsPath string, rulesPath string) (*moderation.Chain, []watcher, error) {
+  words, err := moderation.NewWordList(wordsPath, moderation.ActionMask)
+  if err != nil {
+    return nil, nil, err
+  }
+  chain := moderation.NewChain(words)
+  watchers := []watcher{words}
+
+  if rulesPath != "" {
+    rules, err := moderation.NewRuleSet(rulesPath)
+    if err != nil {
+      return nil, nil, err
+    }
+    chain.Use(rules)
+    watchers = append(watchers, rules)
+  }
+  return chain, watchers, nil
+}
+
+// moderateChirp runs a chirp body through the moderation chain. If the
+// chirp is rejected it records why, responds 400 and returns false.
+func (cfg *apiConfig) moderateChirp(w http.ResponseWriter, user database.User, source string, body string) (moderation.Result, bool) {
+  result := cfg.moderation.Moderate(body)
+  if !result.Rejected {
+    return result, true
+  }
+
+  cfg.recordModeration(result, 0, user.Id, source, body)
+
+  reasons := []moderation.Match{}
+  for _, match := range result.Matches {
+    if match.Action == moderation.ActionReject {
+      reasons = append(reasons, match)
+    }
+  }
+  data, err := json.Marshal(struct {
+    Error string `json:"error"`
+    Matches []moderation.Match `json:"matches"`
+  }{"Chirp was rejected by moderation", reasons})
+  if err != nil {
+    w.WriteHeader(500)
+    return result, false
+  }
+  w.WriteHeader(400)
+  w.Write(data)
+  return result, false
+}
+
+// recordModeration saves a moderation result if any rule matched. The
+// chirp has already been handled by now, so failures are only logged.
+func (cfg *apiConfig) recordModeration(result moderation.Result, chirpId int, authorId int, source string, body string) {
+  if len(result.Matches) == 0 {
+    return
+  }
+
+  matches := []database.ModerationMatch{}
+  for _, match := range result.Matches {
+    matches = append(matches, database.ModerationMatch{
+      Rule: match.Rule,
+      Action: string(match.Action),
+      Text: match.Text,
+      Start: match.Start,
+      End: match.End,
+    })
+  }
+
+  _, err := cfg.database.RecordModerationResult(database.ModerationResult{
+    ChirpId: chirpId,
+    AuthorId: authorId,
+    Source: source,
+    Body: body,
+    Rejected: result.Rejected,
+    Flagged: result.Flagged,
+    Matches: matches,
+  })
+  if err != nil {
+    log.Printf("Unable to record moderation result: %v", err)
+  }
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+  "log"
+  "fmt"
+  "errors"
+  "strconv"
+  "net/http"
+  "encoding/json"
+
+  "github.com/kekekekyle/database"
+)
+
+// notificationDelivery sends notifications to users somewhere other
+// than the notifications endpoint, such as a webhook or email
+type notificationDelivery interface {
+  Deliver(user database.User, notification database.Notification) error
+}
+
+// logDelivery writes notifications to the server log, which stands in
+// for a real channel during development
+type logDelivery struct{}
+
+func (logDelivery) Deliver(user database.User, notification database.Notification) error {
+  log.Printf("Notification %d for %s: %s by user %d", notification.Id, user.Email, notification.Type, notification.ActorId)
+  return nil
+}
+
+// notifier turns events into notifications, stores them and hands new
+// ones to every delivery
+type notifier struct {
+  database database.Store
+  deliveries []notificationDelivery
+}
+
+func newNotifier(db database.Store, deliveries ...notificationDelivery) *notifier {
+  return &notifier{database: db, deliveries: deliveries}
+}
+
+// subscribe registers the notifier for the events it turns into notifications
+func (n *notifier) subscribe(bus *eventBus) {
+  bus.Subscribe(EventChirpCreated, n.chirpCreated)
+  bus.Subscribe(EventChirpDeleted, n.chirpDeleted)
+  bus.Subscribe(EventChirpModerated, n.chirpModerated)
+  bus.Subscribe(EventChirpLiked, n.chirpInteraction(database.NotificationLike))
+  bus.Subscribe(EventChirpRechirped, n.chirpInteraction(database.NotificationRechirp))
+  bus.Subscribe(EventUserFollowed, n.userFollowed)
+  bus.Subscribe(EventMessageSent, n.messageSent)
+}
+
+// notify stores a notification and delivers it if it's new. Nobody is
+// notified of their own actions.
+func (n *notifier) notify(notification database.Notification) {
+  if notification.UserId == 0 || notification.UserId == notification.ActorId {
+    return
+  }
+
+  stored, created, err := n.database.Notify(notification)
+  if err != nil {
+    log.Printf("Unable to store %s notification for user %d: %v", notification.Type, notification.UserId, err)
+    return
+  }
+  if !created || len(n.deliveries) == 0 {
+    return
+  }
+
+  user, err := n.database.FindUserById(stored.UserId)
+  if err != nil {
+    return
+  }
+  for _, delivery := range n.deliveries {
+    go func(delivery notificationDelivery) {
+      if err := delivery.Deliver(user, stored); err != nil {
+        log.Printf("Unable to deliver notification %d: %v", stored.Id, err)
+      }
+    }(delivery)
+  }
+}
+
+// chirpCreated notifies the author of the chirp replied to and the
+// users mentioned. A reply that mentions its parent's author only
+// notifies them once.
+func (n *notifier) chirpCreated(event Event) {
+  notified := map[int]bool{}
+  if event.Chirp.InReplyTo != 0 {
+    if parent, err := n.database.FindChirpById(event.Chirp.InReplyTo); err == nil {
+      notified[parent.AuthorId] = true
+      n.notify(database.Notification{
+        UserId: parent.AuthorId,
+        Type: database.NotificationReply,
+        ActorId: event.ActorId,
+        ChirpId: event.Chirp.Id,
+      })
+    }
+  }
+
+  for _, mention := range event.Chirp.Mentions {
+    if notified[mention.UserId] {
+      continue
+    }
+    notified[mention.UserId] = true
+    n.notify(database.Notification{
+      UserId: mention.UserId,
+      Type: database.NotificationMention,
+      ActorId: event.ActorId,
+      ChirpId: event.Chirp.Id,
+    })
+  }
+}
+
+// chirpDeleted tells an author when someone else deleted their chirp
+func (n *notifier) chirpDeleted(event Event) {
+  n.notify(database.Notification{
+    UserId: event.Chirp.AuthorId,
+    Type: database.NotificationModeration,
+    ActorId: event.ActorId,
+    ChirpId: event.Chirp.Id,
+    Action: database.ModerationDelete,
+  })
+}
+
+// chirpModerated tells an author an admin acted on their chirp.
+// Dismissing its reports leaves the chirp as it was, so that isn't.
+func (n *notifier) chirpModerated(event Event) {
+  if event.Action == database.ModerationDismiss {
+    return
+  }
+  n.notify(database.Notification{
+    UserId: event.Chirp.AuthorId,
+    Type: database.NotificationModeration,
+    ActorId: event.ActorId,
+    ChirpId: event.Chirp.Id,
+    Action: event.Action,
+    Note: event.Note,
+  })
+}
+
+// chirpInteraction notifies the author of the chirp liked or
+// rechirped. For a rechirp the event's chirp is the rechirp itself.
+func (n *notifier) chirpInteraction(notificationType string) func(Event) {
+  return func(event Event) {
+    chirp := event.Chirp
+    if chirp.Rechirped != nil {
+      chirp = *chirp.Rechirped
+    }
+    n.notify(database.Notification{
+      UserId: chirp.AuthorId,
+      Type: notificationType,
+      ActorId: event.ActorId,
+      ChirpId: chirp.Id,
+    })
+  }
+}
+
+func (n *notifier) userFollowed(event Event) {
+  n.notify(database.Notification{
+    UserId: event.UserId,
+    Type: database.NotificationFollow,
+    ActorId: event.ActorId,
+  })
+}
+
+// messageSent notifies the other participant. Until they read it,
+// further messages from the same sender add nothing new.
+func (n *notifier) messageSent(event Event) {
+  for _, userId := range event.Conversation.ParticipantIds {
+    n.notify(database.Notification{
+      UserId: userId,
+      Type: database.NotificationMessage,
+      ActorId: event.ActorId,
+      ConversationId: event.Conversation.Id,
+    })
+  }
+}
+
+type HandleListNotifications struct {
+  api *apiConfig
+}
+
+// ServeHTTP returns a page of the user's notifications, newest first.
+// unread=true leaves out ones already read. The X-Unread-Count header
+// holds how many are unread in total.
+func (h *HandleListNotifications) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  limit, ok := parsePageLimit(w, r)
+  if !ok {
+    return
+  }
+
+  query := database.NotificationQuery{
+    UserId: user.Id,
+    Limit: limit,
+    Cursor: r.URL.Query().Get("cursor"),
+  }
+  if unread := r.URL.Query().Get("unread"); unread != "" {
+    parsed, err := strconv.ParseBool(unread)
+    if err != nil {
+      writeFilterErrors(w, []filterError{{Param: "unread", Value: unread, Message: "must be true or false"}})
+      return
+    }
+    query.Unread = parsed
+  }
+
+  page, err := h.api.database.ListNotifications(query)
+  if errors.Is(err, database.ErrInvalidCursor) {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": %q}`, err.Error())))
+    return
+  }
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  if link := pageLinks(r.URL, page.NextCursor, page.PrevCursor); link != "" {
+    w.Header().Set("Link", link)
+  }
+  w.Header().Set("X-Unread-Count", strconv.Itoa(page.UnreadCount))
+  data, err := json.Marshal(page.Notifications)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
+
+type HandleReadNotifications struct {
+  api *apiConfig
+}
+
+// ServeHTTP marks one of the user's notifications as read
+func (h *HandleReadNotifications) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  notificationId, err := strconv.Atoi(r.PathValue("notificationId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  notification, err := h.api.database.MarkNotificationRead(notificationId, user.Id)
+  if err != nil {
+    w.WriteHeader(404)
+    return
+  }
+  data, err := json.Marshal(notification)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
+
+type HandleReadAllNotifications struct {
+  api *apiConfig
+}
+
+// ServeHTTP marks all of the user's notifications as read
+func (h *HandleReadAllNotifications) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  marked, err := h.api.database.MarkAllNotificationsRead(user.Id)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  data, err := json.Marshal(map[string]int{"marked": marked})
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+  "fmt"
+  "testing"
+  "encoding/json"
+)
+
+func TestListNotifications(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("author@example.com")
+  fanId, fanToken := api.signUp("fan@example.com")
+
+  _, chirp := api.do("POST", "/api/chirps", token, map[string]string{"body": "Like this"})
+  path := fmt.Sprintf("/api/chirps/%d/likes", int(chirp["id"].(float64)))
+  for i := 0; i < 2; i++ {
+    if status, _ := api.do("POST", path, fanToken, nil); status != 200 {
+      t.Fatalf("Like responded %v", status)
+    }
+    if status, _ := api.do("DELETE", path, fanToken, nil); status != 200 {
+      t.Fatalf("Unlike responded %v", status)
+    }
+  }
+
+  status, data := api.send("GET", "/api/notifications", "Bearer "+token, nil)
+  notifications := []map[string]interface{}{}
+  if err := json.Unmarshal(data, &notifications); status != 200 || err != nil {
+    t.Fatalf("List responded %v: %s", status, data)
+  }
+  if len(notifications) != 1 || notifications[0]["type"] != "like" || int(notifications[0]["actor_id"].(float64)) != fanId {
+    t.Errorf("Got %v, expected one like from user %v", notifications, fanId)
+  }
+
+  if status, _ := api.do("GET", "/api/notifications?cursor=not-a-cursor", token, nil); status != 400 {
+    t.Errorf("List with a bad cursor responded %v", status)
+  }
+}
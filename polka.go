@@ -2,9 +2,11 @@ package main
 
 import (
   "fmt"
+  "time"
   "net/http"
   "encoding/json"
   "strings"
+  "github.com/kekekekyle/database"
 )
 
 type PolkaData struct {
@@ -43,15 +45,25 @@ func (cfg *apiConfig) handlePolkaWebhooks (w http.ResponseWriter, r *http.Reques
     return
   }
 
-  user, err := cfg.database.FindUserById(polka.Data.UserId)
-  if err != nil {
+  // Only flip the flag on the stored user, so the upgrade can't undo a
+  // login or profile change that lands at the same time
+  found := true
+  err := cfg.database.Update(func(dbStructure *database.DBStructure) error {
+    user, ok := dbStructure.Users[polka.Data.UserId]
+    if !ok {
+      found = false
+      return fmt.Errorf("No user found with id: %v", polka.Data.UserId)
+    }
+    user.IsChirpyRed = true
+    user.UpdatedAt = time.Now().UTC()
+    dbStructure.PutUser(user)
+    return nil
+  })
+  if !found {
     w.WriteHeader(404)
     w.Write([]byte(fmt.Sprintf("%v", err)))
     return
   }
-
-  user.IsChirpyRed = true
-  _, err = cfg.database.UpdateUser(user)
   if err != nil {
     w.WriteHeader(500)
     w.Write([]byte(fmt.Sprintf("%v", err)))
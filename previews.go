@@ -0,0 +1,120 @@
+package main
+
+import (
+  "log"
+  "sync"
+  "time"
+  "context"
+
+  "github.com/kekekekyle/database"
+  "github.com/kekekekyle/previews"
+)
+
+// linkPreviewer fetches previews of the links in new and edited chirps
+// in the background and caches them in the database, where they're
+// attached to chirps as they're read
+type linkPreviewer struct {
+  database database.Store
+  fetcher *previews.Fetcher
+  // ttl is how long a cached preview is used before it's fetched again
+  ttl time.Duration
+  queue chan string
+  mux sync.Mutex
+  // pending holds the URLs queued or being fetched, so a link chirped
+  // again meanwhile isn't fetched twice
+  pending map[string]bool
+}
+
+func newLinkPreviewer(db database.Store, fetcher *previews.Fetcher, ttl time.Duration, queueSize int) *linkPreviewer {
+  return &linkPreviewer{
+    database: db,
+    fetcher: fetcher,
+    ttl: ttl,
+    queue: make(chan string, queueSize),
+    pending: map[string]bool{},
+  }
+}
+
+// subscribe queues the links of chirps as they're created or edited
+func (p *linkPreviewer) subscribe(bus *eventBus) {
+  bus.Subscribe(EventChirpCreated, p.chirpChanged)
+  bus.Subscribe(EventChirpEdited, p.chirpChanged)
+}
+
+func (p *linkPreviewer) chirpChanged(event Event) {
+  for _, link := range event.Chirp.Links {
+    p.enqueue(link.Url)
+  }
+}
+
+// enqueue queues url to be fetched unless it has a fresh preview or is
+// already queued. When the queue is full the link goes without a
+// preview rather than holding up the request that chirped it.
+func (p *linkPreviewer) enqueue(url string) {
+  if cached, err := p.database.FindLinkPreview(url); err == nil && time.Since(cached.FetchedAt) < p.ttl {
+    return
+  }
+
+  p.mux.Lock()
+  defer p.mux.Unlock()
+  if p.pending[url] {
+    return
+  }
+  select {
+  case p.queue <- url:
+    p.pending[url] = true
+  default:
+    log.Printf("Link preview queue is full, skipping %s", url)
+  }
+}
+
+// run fetches queued links with the given number of workers until stop
+// is closed
+func (p *linkPreviewer) run(workers int, stop <-chan struct{}) {
+  ctx, cancel := context.WithCancel(context.Background())
+  go func() {
+    <-stop
+    cancel()
+  }()
+
+  for i := 0; i < workers; i++ {
+    go func() {
+      for {
+        select {
+        case <-ctx.Done():
+          return
+        case url := <-p.queue:
+          p.fetch(ctx, url)
+        }
+      }
+    }()
+  }
+}
+
+// fetch previews url and caches the result, failures included
+func (p *linkPreviewer) fetch(ctx context.Context, url string) {
+  defer func() {
+    p.mux.Lock()
+    delete(p.pending, url)
+    p.mux.Unlock()
+  }()
+
+  cached := database.LinkPreview{Url: url, Status: database.LinkPreviewReady}
+  preview, err := p.fetcher.Fetch(ctx, url)
+  if err != nil {
+    if ctx.Err() != nil {
+      return
+    }
+    cached.Status = database.LinkPreviewFailed
+    cached.Error = err.Error()
+  } else {
+    cached.Title = preview.Title
+    cached.Description = preview.Description
+    cached.Image = preview.Image
+    cached.SiteName = preview.SiteName
+  }
+
+  if _, err := p.database.SaveLinkPreview(cached); err != nil {
+    log.Printf("Unable to save link preview of %s: %v", url, err)
+  }
+}
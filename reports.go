@@ -0,0 +1,197 @@
+package main
+
+import (
+  "fmt"
+  "strconv"
+  "net/http"
+  "encoding/json"
+  "unicode/utf8"
+
+  "github.com/kekekekyle/database"
+)
+
+// maxReportReasonLength caps how much a reporter can write, in runes
+const maxReportReasonLength = 500
+
+type HandleReportChirps struct {
+  api *apiConfig
+}
+
+func (h *HandleReportChirps) ServeHTTP (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirpIndex, err := strconv.Atoi(r.PathValue("chirpId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  type parameters struct {
+    Reason string `json:"reason"`
+  }
+  params := parameters{}
+  decoder := json.NewDecoder(r.Body)
+  if err := decoder.Decode(&params); err != nil {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "Something went wrong"}`))
+    return
+  }
+  if utf8.RuneCountInString(params.Reason) > maxReportReasonLength {
+    w.WriteHeader(400)
+    w.Write([]byte(fmt.Sprintf(`{"error": "reason must be at most %d characters"}`, maxReportReasonLength)))
+    return
+  }
+
+  foundChirp, err := h.api.database.FindChirpById(chirpIndex)
+  if err != nil || !foundChirp.IsListed() {
+    w.WriteHeader(404)
+    return
+  }
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  report, err := h.api.database.ReportChirp(chirpIndex, user.Id, params.Reason)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  data, err := json.Marshal(report)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+
+  w.WriteHeader(201)
+  w.Write(data)
+}
+
+// handleModerationQueue lists chirps with open reports, oldest first
+func (cfg *apiConfig) handleModerationQueue (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  queue, err := cfg.database.ModerationQueue()
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  data, err := json.Marshal(queue)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
+
+// handleModerateChirp applies an admin's hide, delete, dismiss or
+// warn action to a chirp and resolves its reports
+func (cfg *apiConfig) handleModerateChirp (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirpIndex, err := strconv.Atoi(r.PathValue("chirpId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  type parameters struct {
+    Action string `json:"action"`
+    Note string `json:"note"`
+  }
+  params := parameters{}
+  decoder := json.NewDecoder(r.Body)
+  if err := decoder.Decode(&params); err != nil {
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "Something went wrong"}`))
+    return
+  }
+
+  switch params.Action {
+  case database.ModerationHide, database.ModerationDelete, database.ModerationDismiss, database.ModerationWarn:
+  default:
+    w.WriteHeader(400)
+    w.Write([]byte(`{"error": "action must be hide, delete, dismiss or warn"}`))
+    return
+  }
+
+  if _, err := cfg.database.FindChirpById(chirpIndex); err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  userHeader := r.Header.Get("User")
+  user := database.User{}
+  if err := json.Unmarshal([]byte(userHeader), &user); err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  entry, err := cfg.database.ModerateChirp(chirpIndex, user.Id, params.Action, params.Note)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  moderatedChirp, err := cfg.database.FindChirpById(chirpIndex)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+  cfg.events.Publish(Event{
+    Type: EventChirpModerated,
+    ActorId: user.Id,
+    Chirp: moderatedChirp,
+    Action: params.Action,
+    Note: params.Note,
+  })
+
+  data, err := json.Marshal(entry)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+
+  w.WriteHeader(201)
+  w.Write(data)
+}
+
+// handleModerationLog lists moderation actions, optionally only those
+// taken on the chirp given by chirp_id
+func (cfg *apiConfig) handleModerationLog (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirpId := 0
+  if qChirpId := r.URL.Query().Get("chirp_id"); qChirpId != "" {
+    parsed, err := strconv.Atoi(qChirpId)
+    if err != nil || parsed < 1 {
+      writeFilterErrors(w, []filterError{{Param: "chirp_id", Value: qChirpId, Message: "must be a positive integer"}})
+      return
+    }
+    chirpId = parsed
+  }
+
+  entries, err := cfg.database.ListModerationLog(chirpId)
+  if err != nil {
+    w.WriteHeader(500)
+    w.Write([]byte(fmt.Sprintf("%v", err)))
+    return
+  }
+
+  data, err := json.Marshal(entries)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+  "fmt"
+  "testing"
+)
+
+func TestModerationEventHasChirpAfterAction(t *testing.T) {
+  api := newTestAPI(t)
+  _, token := api.signUp("author@example.com")
+  _, adminToken := api.signUp("admin@example.com")
+  api.cfg.adminEmails["admin@example.com"] = true
+
+  events := []Event{}
+  api.cfg.events.Subscribe(EventChirpModerated, func(event Event) {
+    events = append(events, event)
+  })
+
+  _, chirp := api.do("POST", "/api/chirps", token, map[string]string{"body": "Questionable"})
+  path := fmt.Sprintf("/admin/moderation/chirps/%d", int(chirp["id"].(float64)))
+  if status, _ := api.do("POST", path, adminToken, map[string]string{"action": "hide"}); status != 201 {
+    t.Fatalf("Hide responded %v", status)
+  }
+
+  if len(events) != 1 {
+    t.Fatalf("Got %v moderation events, expected 1", len(events))
+  }
+  if !events[0].Chirp.IsHidden() || events[0].Chirp.IsListed() {
+    t.Errorf("Event has the chirp as it was before the action: %+v", events[0].Chirp)
+  }
+}
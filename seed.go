@@ -0,0 +1,124 @@
+package main
+
+import (
+  "fmt"
+  "os"
+  "encoding/json"
+  "github.com/kekekekyle/database"
+  "golang.org/x/crypto/bcrypt"
+)
+
+// SeedUser is a user in a fixture file. Password is plaintext
+// and is hashed when the fixture is loaded.
+type SeedUser struct {
+  Email string `json:"email"`
+  Password string `json:"password"`
+}
+
+// SeedMembership gives a fixture user a paid plan
+type SeedMembership struct {
+  User string `json:"user"`
+  Plan string `json:"plan"`
+}
+
+// SeedChirp is a chirp in a fixture file, attributed by author email
+type SeedChirp struct {
+  Author string `json:"author"`
+  Body string `json:"body"`
+}
+
+// Fixture describes a known database state for development and CI
+type Fixture struct {
+  Users []SeedUser `json:"users"`
+  Memberships []SeedMembership `json:"memberships"`
+  Chirps []SeedChirp `json:"chirps"`
+}
+
+const planChirpyRed = "chirpy_red"
+
+// loadFixture reads a fixture file, rejecting unknown fields so
+// typos don't silently seed the wrong data
+func loadFixture(path string) (Fixture, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return Fixture{}, err
+  }
+  defer file.Close()
+
+  decoder := json.NewDecoder(file)
+  decoder.DisallowUnknownFields()
+  fixture := Fixture{}
+  if err := decoder.Decode(&fixture); err != nil {
+    return Fixture{}, fmt.Errorf("Unable to read fixture %s: %v", path, err)
+  }
+  return fixture, nil
+}
+
+// seedDatabase empties the store and loads the fixture into it in
+// file order, so the same fixture always produces the same ids
+func seedDatabase(store database.Store, fixture Fixture) error {
+  if err := store.Reset(); err != nil {
+    return err
+  }
+
+  users := map[string]database.User{}
+  for _, seedUser := range fixture.Users {
+    hashedPassword, err := bcrypt.GenerateFromPassword(
+      []byte(seedUser.Password),
+      passwordCost,
+    )
+    if err != nil {
+      return err
+    }
+
+    createdUser, err := store.CreateUser(database.User{
+      Email: seedUser.Email,
+      Password: string(hashedPassword),
+    })
+    if err != nil {
+      return fmt.Errorf("Unable to seed user %s: %v", seedUser.Email, err)
+    }
+    users[createdUser.Email] = createdUser
+  }
+
+  for _, membership := range fixture.Memberships {
+    user, ok := users[membership.User]
+    if !ok {
+      return fmt.Errorf("Membership for unknown user: %s", membership.User)
+    }
+    if membership.Plan != planChirpyRed {
+      return fmt.Errorf("Unknown plan for %s: %s", membership.User, membership.Plan)
+    }
+
+    user.IsChirpyRed = true
+    if _, err := store.UpdateUser(user); err != nil {
+      return err
+    }
+    users[user.Email] = user
+  }
+
+  for _, seedChirp := range fixture.Chirps {
+    author, ok := users[seedChirp.Author]
+    if !ok {
+      return fmt.Errorf("Chirp by unknown user: %s", seedChirp.Author)
+    }
+    if _, err := store.CreateChirp(seedChirp.Body, author.Id); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// seedFromFile loads a fixture file into the store
+func (cfg *apiConfig) seedFromFile (path string) error {
+  fixture, err := loadFixture(path)
+  if err != nil {
+    return err
+  }
+  if err := seedDatabase(cfg.database, fixture); err != nil {
+    return err
+  }
+  fmt.Printf("Seeded %d users and %d chirps from %s\n", len(fixture.Users), len(fixture.Chirps), path)
+  return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+  "fmt"
+  "strconv"
+  "net/http"
+  "encoding/json"
+)
+
+const (
+  // defaultThreadDepth is how many levels of replies below the chirp
+  // a thread shows when the request doesn't say
+  defaultThreadDepth = 10
+  // maxThreadDepth caps the depth clients can ask for
+  maxThreadDepth = 50
+)
+
+// handleGetChirpThread returns the conversation a chirp belongs to as
+// a tree of replies, starting from the chirp that began it
+func (cfg *apiConfig) handleGetChirpThread (w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+
+  chirpIndex, err := strconv.Atoi(r.PathValue("chirpId"))
+  if err != nil {
+    w.WriteHeader(400)
+    return
+  }
+
+  depth := defaultThreadDepth
+  if qDepth := r.URL.Query().Get("depth"); qDepth != "" {
+    depth, err = strconv.Atoi(qDepth)
+    if err != nil || depth < 0 || depth > maxThreadDepth {
+      writeFilterErrors(w, []filterError{{
+        Param: "depth",
+        Value: qDepth,
+        Message: fmt.Sprintf("must be between 0 and %d", maxThreadDepth),
+      }})
+      return
+    }
+  }
+
+  thread, err := cfg.database.GetThread(chirpIndex, depth)
+  if err != nil {
+    w.WriteHeader(404)
+    return
+  }
+
+  data, err := json.Marshal(thread)
+  if err != nil {
+    w.WriteHeader(500)
+    return
+  }
+  w.Write(data)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
   "fmt"
+  "time"
   "net/http"
   "encoding/json"
   "strings"
@@ -11,6 +12,9 @@ import (
   "github.com/golang-jwt/jwt/v5"
 )
 
+// passwordCost is the bcrypt cost passwords are hashed with
+var passwordCost = bcrypt.DefaultCost
+
 func (cfg *apiConfig) handleUpdateUser (w http.ResponseWriter, r *http.Request) {
   w.Header().Set("Content-Type", "application/json")
   authorizationHeader := r.Header.Get("Authorization")
@@ -55,21 +59,35 @@ func (cfg *apiConfig) handleUpdateUser (w http.ResponseWriter, r *http.Request)
     if err != nil {
       w.WriteHeader(401)
       w.Write([]byte(fmt.Sprintf("%v", err)))
+      return
     }
 
-    foundUser, err := cfg.database.FindUserById(userId)
+    hashedPassword, err := bcrypt.GenerateFromPassword(
+      []byte(user.Password),
+      passwordCost,
+    )
     if err != nil {
-      w.WriteHeader(401)
+      w.WriteHeader(500)
       w.Write([]byte(fmt.Sprintf("%v", err)))
+      return
     }
 
-    foundUser.Email = user.Email
-    hashedPassword, err := bcrypt.GenerateFromPassword(
-      []byte(user.Password),
-      bcrypt.DefaultCost,
-    )
-    foundUser.Password = string(hashedPassword)
-    updateUser, err := cfg.database.UpdateUser(foundUser)
+    // Change only the email and password on the stored user, so a login
+    // or upgrade that lands in between isn't undone
+    var updateUser database.User
+    err = cfg.database.Update(func(dbStructure *database.DBStructure) error {
+      storedUser, ok := dbStructure.Users[userId]
+      if !ok {
+        return fmt.Errorf("No user found with id: %v", userId)
+      }
+      storedUser.Email = user.Email
+      storedUser.Password = string(hashedPassword)
+      storedUser.UpdatedAt = time.Now().UTC()
+      dbStructure.PutUser(storedUser)
+
+      updateUser = storedUser
+      return nil
+    })
     if err != nil {
       w.WriteHeader(500)
       w.Write([]byte(fmt.Sprintf("%v", err)))
@@ -80,11 +98,15 @@ func (cfg *apiConfig) handleUpdateUser (w http.ResponseWriter, r *http.Request)
       Id int `json:"id"`
       Email string `json:"email"`
       RefreshToken string `json:"refresh_token"`
+      CreatedAt time.Time `json:"created_at"`
+      UpdatedAt time.Time `json:"updated_at"`
     }
     returnUser := returnedUser{
       Id: updateUser.Id,
       Email: updateUser.Email,
       RefreshToken: updateUser.RefreshToken.RefreshToken,
+      CreatedAt: updateUser.CreatedAt,
+      UpdatedAt: updateUser.UpdatedAt,
     }
 
     data, err := json.Marshal(returnUser)
@@ -122,7 +144,7 @@ func (cfg *apiConfig) handleCreateUser (w http.ResponseWriter, r *http.Request)
 
   hashedPassword, err := bcrypt.GenerateFromPassword(
     []byte(user.Password),
-    bcrypt.DefaultCost,
+    passwordCost,
   )
   if err != nil {
     w.WriteHeader(500)
@@ -144,6 +166,8 @@ func (cfg *apiConfig) handleCreateUser (w http.ResponseWriter, r *http.Request)
     Token string `json:"-"`
     RefreshToken database.RefreshToken `json:"-"`
     IsChirpyRed bool `json:"is_chirpy_red"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
   }
 
   data, err := json.Marshal(returnedUser(createdUser))